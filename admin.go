@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OwnershipRequest is a request by a group owner to hand the group to another member
+type OwnershipRequest struct {
+	UserID string `json:"userId"`
+}
+
+// AdminsResponse lists a group's owner and admins
+type AdminsResponse struct {
+	OwnerID   primitive.ObjectID   `json:"ownerId"`
+	Admins    []primitive.ObjectID `json:"admins"`
+	MaxAdmins int                  `json:"maxAdmins"`
+}
+
+// Bind binds the http req to ownershipRequest type as the render
+func (or *OwnershipRequest) Bind(r *http.Request) error {
+	if or.UserID == "" {
+		return errors.New("must name the new owner")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (ar *AdminsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewAdminsResponse creates a client response of a group's admins
+func NewAdminsResponse(g Group) *AdminsResponse {
+	return &AdminsResponse{g.Owner(), g.Admins, g.AdminLimit()}
+}
+
+/*promoteFilter matches the group only while it has room for another admin, so concurrent
+promotions cannot exceed the cap */
+func promoteFilter(g Group) bson.M {
+	return bson.M{"_id": g.ID, "admins." + strconv.Itoa(g.AdminLimit()-1): bson.M{"$exists": false}}
+}
+
+////////////  CONTROLLERS //////////////////
+
+// PromoteAdmin makes a group member an admin
+func PromoteAdmin(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	uid, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !g.HasUser(uid) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("only group members can be made admins")))
+		return
+	}
+	if g.HasAdmin(uid) {
+		render.Render(w, r, ErrConflict(errors.New("user is already an admin")))
+		return
+	}
+	// an admin's role is its own, so drop any member role
+	update := bson.M{"$addToSet": bson.M{"admins": uid}, "$unset": bson.M{"roles." + uid.Hex(): ""}}
+	matched, err := mh.UpdateGroupIf(promoteFilter(*g), update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrInvalidRequest(errors.New("group cannot have more than "+strconv.Itoa(g.AdminLimit())+" admins")))
+		return
+	}
+	g.Admins = append(g.Admins, uid)
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewAdminsResponse(*g))
+}
+
+// DemoteAdmin takes admin rights from a group admin. The owner and the last admin cannot be demoted
+func DemoteAdmin(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	uid, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !g.HasAdmin(uid) {
+		render.Render(w, r, ErrNotFound(errors.New("user is not an admin")))
+		return
+	}
+	if g.Owner() == uid {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the group owner must transfer ownership before being demoted")))
+		return
+	}
+	// only matches while another admin remains
+	filter := bson.M{"_id": g.ID, "admins.1": bson.M{"$exists": true}}
+	matched, err := mh.UpdateGroupIf(filter, bson.M{"$pull": bson.M{"admins": uid}})
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the last admin of a group cannot be demoted")))
+		return
+	}
+	admins := make([]primitive.ObjectID, 0, len(g.Admins))
+	for _, a := range g.Admins {
+		if a != uid {
+			admins = append(admins, a)
+		}
+	}
+	g.Admins = admins
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewAdminsResponse(*g))
+}
+
+// TransferOwnership hands a group to another member, who is made an admin if needed
+func TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	data := &OwnershipRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	newOwner, err := primitive.ObjectIDFromHex(data.UserID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !g.HasUser(newOwner) && !g.HasAdmin(newOwner) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("ownership can only be transferred to a group member")))
+		return
+	}
+	filter := bson.M{"_id": g.ID}
+	if !g.HasAdmin(newOwner) {
+		filter = promoteFilter(*g)
+	}
+	update := bson.M{"$set": bson.M{"ownerId": newOwner}, "$addToSet": bson.M{"admins": newOwner}, "$unset": bson.M{"roles." + newOwner.Hex(): ""}}
+	matched, err := mh.UpdateGroupIf(filter, update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrInvalidRequest(errors.New("group cannot have more than "+strconv.Itoa(g.AdminLimit())+" admins")))
+		return
+	}
+	if !g.HasAdmin(newOwner) {
+		g.Admins = append(g.Admins, newOwner)
+	}
+	g.OwnerID = newOwner
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewAdminsResponse(*g))
+}
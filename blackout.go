@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	jdchaimailer "github.com/ede0m/jdchai/mailer"
+	jdscheduler "github.com/ede0m/jdgoscheduler"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Blackout is a period the property is closed. No units are scheduled during it
+type Blackout struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	DateRange  `bson:",inline"`
+	ResourceID primitive.ObjectID `json:"resourceId" bson:"resourceId,omitempty"` // nil for the group's default resource
+	Reason     string             `json:"reason" bson:"reason"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// BlackoutRequest is a request by a group admin to close the property for a period
+type BlackoutRequest struct {
+	DateRange
+	ResourceID string `json:"resourceId"`
+	Reason     string `json:"reason"`
+}
+
+// BlackoutResponse is the client response for an added blackout
+type BlackoutResponse struct {
+	Blackout     Blackout `json:"blackout"`
+	RemovedUnits []string `json:"removedUnits"`
+}
+
+// BlackoutsResponse lists a group's blackouts
+type BlackoutsResponse struct {
+	Blackouts []Blackout `json:"blackouts"`
+}
+
+// Bind binds the http req to blackoutRequest type as the render
+func (br *BlackoutRequest) Bind(r *http.Request) error {
+	return br.validate()
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (br *BlackoutResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (br *BlackoutsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// blackoutRanges returns the date ranges of a group resource's blackouts
+func (g Group) blackoutRanges(resourceID primitive.ObjectID) []DateRange {
+	ranges := make([]DateRange, 0)
+	for _, b := range g.Blackouts {
+		if b.ResourceID == resourceID {
+			ranges = append(ranges, b.DateRange)
+		}
+	}
+	return ranges
+}
+
+// RemoveBlackoutUnits takes every unit overlapping a blackout out of a schedule and returns them
+func RemoveBlackoutUnits(sch *jdscheduler.Schedule, blackouts []DateRange) []jdscheduler.Unit {
+	return removeBlackoutUnits(sch, blackouts, func(u jdscheduler.Unit) time.Time { return u.Start.AddDate(0, 0, unitDays) })
+}
+
+// removeBlackoutUnits takes units out of a schedule that overlap a blackout between their start and end
+func removeBlackoutUnits(sch *jdscheduler.Schedule, blackouts []DateRange, end func(jdscheduler.Unit) time.Time) []jdscheduler.Unit {
+	var removed []jdscheduler.Unit
+	if len(blackouts) == 0 {
+		return removed
+	}
+	for _, s := range sch.Seasons {
+		for j := range s.Blocks {
+			kept := make([]jdscheduler.Unit, 0, len(s.Blocks[j].Units))
+			for _, u := range s.Blocks[j].Units {
+				if inBlackout(u.Start, end(u), blackouts) {
+					removed = append(removed, u)
+					continue
+				}
+				kept = append(kept, u)
+			}
+			s.Blocks[j].Units = kept
+		}
+	}
+	return removed
+}
+
+func inBlackout(start, end time.Time, blackouts []DateRange) bool {
+	for _, b := range blackouts {
+		if b.Overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
+
+/*RemoveUnits takes blacked out units out of the master schedule and its unit map, and reindexes
+the units that are left. It returns the removed units with their owners */
+func (ms *MasterSchedule) RemoveUnits(blackouts []DateRange) map[string]ScheduleMapUnit {
+	removed := make(map[string]ScheduleMapUnit)
+	end := func(u jdscheduler.Unit) time.Time { return ms.ScheduleUnitMap[u.ID.String()].EndDate() }
+	for _, u := range removeBlackoutUnits(&ms.Schedule, blackouts, end) {
+		id := u.ID.String()
+		removed[id] = ms.ScheduleUnitMap[id]
+	}
+	ms.reindexUnits()
+	return removed
+}
+
+// reindexUnits points the schedule unit map at the units' current schedule positions and drops missing units
+func (ms *MasterSchedule) reindexUnits() {
+	seen := make(map[string]bool)
+	for i, s := range ms.Schedule.Seasons {
+		for j, b := range s.Blocks {
+			for k, u := range b.Units {
+				id := u.ID.String()
+				smu, ok := ms.ScheduleUnitMap[id]
+				if !ok {
+					smu = ScheduleMapUnit{Owner: u.Participant, Start: u.Start}
+				}
+				smu.MapIndicies = []int{i, j, k}
+				ms.ScheduleUnitMap[id] = smu
+				seen[id] = true
+			}
+		}
+	}
+	for id := range ms.ScheduleUnitMap {
+		if !seen[id] {
+			delete(ms.ScheduleUnitMap, id)
+		}
+	}
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetBlackouts lists a group's blackouts
+func GetBlackouts(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	blackouts := g.Blackouts
+	if blackouts == nil {
+		blackouts = make([]Blackout, 0)
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &BlackoutsResponse{blackouts})
+}
+
+/*CreateBlackout closes the property for a period. Units of the current master schedule in the period
+are removed, open trades involving them are void and their owners are notified */
+func CreateBlackout(w http.ResponseWriter, r *http.Request) {
+	data := &BlackoutRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	resourceID, err := g.resourceID(data.ResourceID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	b := Blackout{primitive.NewObjectID(), data.DateRange, resourceID, data.Reason, time.Now()}
+	resp := &BlackoutResponse{b, make([]string, 0)}
+
+	// resources without a schedule only record the blackout
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, masterScheduleFilter(g.ID, resourceID)); err == mongo.ErrNoDocuments {
+		ms = nil
+	} else if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	var removed map[string]ScheduleMapUnit
+	var voidUnits []uuid.UUID
+	var before unitsSnapshot
+	if ms != nil {
+		before = ms.snapshot()
+		removed = ms.RemoveUnits([]DateRange{b.DateRange})
+		for id := range removed {
+			voidUnits = append(voidUnits, uuid.MustParse(id))
+			resp.RemovedUnits = append(resp.RemovedUnits, id)
+		}
+	}
+	if err = mh.AddGroupBlackout(g.ID, b, ms, before, voidUnits); err == errScheduleChanged {
+		render.Render(w, r, ErrConflict(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+
+	// one notice per owner, listing their removed units in date order
+	owned := make(map[string][]time.Time)
+	for _, smu := range removed {
+		if smu.Owner != "" {
+			owned[smu.Owner] = append(owned[smu.Owner], smu.Start)
+		}
+	}
+	for owner, starts := range owned {
+		if !notifies(owner, BlackoutNotification) {
+			continue
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		days := make([]string, len(starts))
+		for i, s := range starts {
+			days[i] = s.Format(exportDateLayout)
+		}
+		go jdchaimailer.SendBlackoutNotice(g.Name, owner, days, data.Reason)
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
+
+// DeleteBlackout removes a blackout from a group. Units removed by it are not restored
+func DeleteBlackout(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	blackoutID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "blackoutID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	filter := bson.M{"_id": g.ID, "blackouts._id": blackoutID}
+	update := bson.M{"$pull": bson.M{"blackouts": bson.M{"_id": blackoutID}}}
+	matched, err := mh.UpdateGroupIf(filter, update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrNotFound(errors.New("blackout not found")))
+		return
+	}
+	render.NoContent(w, r)
+}
@@ -0,0 +1,77 @@
+package jdchaiblob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store saves and loads binary objects (photos, documents) by key
+type Store interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// LocalStore keeps objects as files under a root directory
+type LocalStore struct {
+	root string
+}
+
+//NewLocalStore constructor, creates the root directory if needed
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root}, nil
+}
+
+// path maps a key to a file under the root. Keys cannot escape the root
+func (ls *LocalStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", errors.New("invalid blob key: " + key)
+	}
+	return filepath.Join(ls.root, clean), nil
+}
+
+// Put writes an object, replacing any object with the same key
+func (ls *LocalStore) Put(key string, r io.Reader) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+// Get opens an object for reading, the caller closes it
+func (ls *LocalStore) Get(key string) (io.ReadCloser, error) {
+	p, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Delete removes an object
+func (ls *LocalStore) Delete(key string) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
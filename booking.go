@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestBooking is a unit lent by its owner to guests
+type GuestBooking struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	GuestName   string             `json:"guestName" bson:"guestName"`
+	Contact     string             `json:"contact" bson:"contact"`
+	Guests      int                `json:"guests" bson:"guests"`
+	Notes       string             `json:"notes" bson:"notes"`
+	KeepOnTrade bool               `json:"keepOnTrade" bson:"keepOnTrade"` // hand the booking over to the new owner when the unit is traded, cancel it otherwise
+	CreatedBy   primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// GuestBookingRequest is a request by a unit owner or group admin to book guests into a unit
+type GuestBookingRequest struct {
+	GuestName   string `json:"guestName"`
+	Contact     string `json:"contact"`
+	Guests      int    `json:"guests"`
+	Notes       string `json:"notes"`
+	KeepOnTrade bool   `json:"keepOnTrade"`
+}
+
+// GuestBookingResponse is the client response for a guest booking
+type GuestBookingResponse struct {
+	UnitID  string       `json:"unitId"`
+	Booking GuestBooking `json:"booking"`
+}
+
+// Bind binds the http req to guestBookingRequest type as the render
+func (gbr *GuestBookingRequest) Bind(r *http.Request) error {
+	if gbr.GuestName == "" {
+		return errors.New("booking must have a guest name")
+	}
+	if gbr.Guests <= 0 {
+		return errors.New("booking must have at least one guest")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (gbr *GuestBookingResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// guestList describes a unit's bookings, one per guest party
+func (smu ScheduleMapUnit) guestList() []string {
+	guests := make([]string, 0, len(smu.Bookings))
+	for _, b := range smu.Bookings {
+		guests = append(guests, b.GuestName+" ("+strconv.Itoa(b.Guests)+")")
+	}
+	return guests
+}
+
+// handOverBookings keeps the bookings that stay with a unit when it is traded
+func handOverBookings(bookings []GuestBooking) []GuestBooking {
+	var kept []GuestBooking
+	for _, b := range bookings {
+		if b.KeepOnTrade {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+/*ownedUnitFilter matches a schedule only while the user still owns the unit, so a trade executed
+meanwhile does not let its old owner change the unit's bookings. Group admins can change any unit */
+func ownedUnitFilter(g *Group, ms *MasterSchedule, u *User, unitID string) bson.M {
+	filter := bson.M{"_id": ms.ID}
+	if !g.Can(u.ID, ManageGroup) {
+		filter["scheduleUnitMap."+unitID+".owner"] = u.Email
+	}
+	return filter
+}
+
+// hideContacts removes the guest contacts from the bookings of units not owned by email
+func (msr *MasterScheduleResponse) hideContacts(email string) {
+	for id, unit := range msr.Units {
+		if len(unit.Bookings) == 0 || (email != "" && unit.Owner == email) {
+			continue
+		}
+		bookings := make([]GuestBooking, len(unit.Bookings))
+		for i, b := range unit.Bookings {
+			b.Contact = ""
+			bookings[i] = b
+		}
+		unit.Bookings = bookings
+		msr.Units[id] = unit
+	}
+}
+
+// hasBookings checks whether any unit of the response has guest bookings
+func (msr *MasterScheduleResponse) hasBookings() bool {
+	for _, unit := range msr.Units {
+		if len(unit.Bookings) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+////////////  CONTROLLERS //////////////////
+
+// CreateGuestBooking books guests into a unit of a group resource's current schedule (?resource=id)
+func CreateGuestBooking(w http.ResponseWriter, r *http.Request) {
+	data := &GuestBookingRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, ms, u, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	unitID := chi.URLParam(r, "unitID")
+	smu, ok := ms.ScheduleUnitMap[unitID]
+	if !ok {
+		render.Render(w, r, ErrNotFound(errors.New("unit not in schedule")))
+		return
+	}
+	// bookings are made by the unit's owner or an admin of group
+	if smu.Owner != u.Email && !g.Can(u.ID, ManageGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("only the unit owner or a group admin can book guests")))
+		return
+	}
+	b := GuestBooking{primitive.NewObjectID(), data.GuestName, data.Contact, data.Guests, data.Notes, data.KeepOnTrade, u.ID, time.Now()}
+	update := bson.M{"$push": bson.M{"scheduleUnitMap." + unitID + ".bookings": b}}
+	matched, err := mh.UpdateMasterScheduleIf(ownedUnitFilter(g, ms, u, unitID), update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrConflict(errors.New("unit changed owner, try again")))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &GuestBookingResponse{unitID, b})
+}
+
+// DeleteGuestBooking cancels a guest booking
+func DeleteGuestBooking(w http.ResponseWriter, r *http.Request) {
+	g, ms, u, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "bookingID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	unitID := chi.URLParam(r, "unitID")
+	smu, ok := ms.ScheduleUnitMap[unitID]
+	if !ok {
+		render.Render(w, r, ErrNotFound(errors.New("unit not in schedule")))
+		return
+	}
+	if smu.Owner != u.Email && !g.Can(u.ID, ManageGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("only the unit owner or a group admin can cancel bookings")))
+		return
+	}
+	update := bson.M{"$pull": bson.M{"scheduleUnitMap." + unitID + ".bookings": bson.M{"_id": bookingID}}}
+	matched, err := mh.UpdateMasterScheduleIf(ownedUnitFilter(g, ms, u, unitID), update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrConflict(errors.New("unit changed owner, try again")))
+		return
+	}
+	render.NoContent(w, r)
+}
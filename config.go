@@ -13,4 +13,5 @@ type Configuration struct {
 	APIMailerAddress  string
 	APIMailerPassword string
 	ClientBaseURL     string
+	BlobRoot          string // directory of the local blob store
 }
@@ -43,6 +43,15 @@ func ErrAuth(err error) render.Renderer {
 	}
 }
 
+func ErrForbidden(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 403,
+		StatusText:     "Forbidden",
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrNotFound(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Expense split rules
+const (
+	EqualSplit  = "equal"  // every group member pays the same
+	UnitsSplit  = "units"  // in proportion to the units each member owns in the current master schedule
+	CustomSplit = "custom" // the amounts given with the expense
+)
+
+// Expense is a cost paid by one member and shared by the group. Amounts are in the currency's minor unit (cents)
+type Expense struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	GroupID     primitive.ObjectID `json:"groupId" bson:"groupId"`
+	Description string             `json:"description" bson:"description"`
+	Amount      int64              `json:"amount" bson:"amount"`
+	Currency    string             `json:"currency" bson:"currency"`
+	PayerID     primitive.ObjectID `json:"payerId" bson:"payerId"`
+	Split       string             `json:"split" bson:"split"`
+	Shares      []ExpenseShare     `json:"shares" bson:"shares"` // resolved when the expense is added
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// ExpenseShare is the part of an expense one member owes
+type ExpenseShare struct {
+	UserID primitive.ObjectID `json:"userId" bson:"userId"`
+	Amount int64              `json:"amount" bson:"amount"`
+}
+
+// Settlement is a payment between two members to settle up balances
+type Settlement struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	GroupID   primitive.ObjectID `json:"groupId" bson:"groupId"`
+	FromID    primitive.ObjectID `json:"fromId" bson:"fromId"`
+	ToID      primitive.ObjectID `json:"toId" bson:"toId"`
+	Amount    int64              `json:"amount" bson:"amount"`
+	Currency  string             `json:"currency" bson:"currency"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// ExpenseRequest is a request by a group member to add an expense
+type ExpenseRequest struct {
+	Description string           `json:"description"`
+	Amount      int64            `json:"amount"`
+	Currency    string           `json:"currency"`
+	PayerID     string           `json:"payerId"` // defaults to the requesting user
+	Split       string           `json:"split"`
+	ResourceID  string           `json:"resourceId"` // schedule used by the units split
+	Shares      map[string]int64 `json:"shares"`     // user id to amount for the custom split
+}
+
+// SettlementRequest records a payment from the requesting user (or any member, by an admin) to another member
+type SettlementRequest struct {
+	FromID   string `json:"fromId"`
+	ToID     string `json:"toId"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ExpensesResponse lists a group's expenses and settlements
+type ExpensesResponse struct {
+	Expenses    []*Expense    `json:"expenses"`
+	Settlements []*Settlement `json:"settlements"`
+}
+
+// Debt is an amount one member owes another
+type Debt struct {
+	FromID primitive.ObjectID `json:"fromId"`
+	ToID   primitive.ObjectID `json:"toId"`
+	Amount int64              `json:"amount"`
+}
+
+// CurrencyBalance is what each member is owed (positive) or owes (negative) in a currency
+type CurrencyBalance struct {
+	Net   map[string]int64 `json:"net"`
+	Debts []Debt           `json:"debts"` // payments that would settle everyone up
+}
+
+// BalancesResponse is a group's balances by currency
+type BalancesResponse struct {
+	Balances map[string]*CurrencyBalance `json:"balances"`
+}
+
+// Bind binds the http req to expenseRequest type as the render
+func (er *ExpenseRequest) Bind(r *http.Request) error {
+	er.Currency = strings.ToUpper(er.Currency)
+	if er.Amount <= 0 {
+		return errors.New("expense amount must be positive")
+	}
+	if len(er.Currency) != 3 {
+		return errors.New("expense currency must be a 3 letter code")
+	}
+	if er.Split == "" {
+		er.Split = EqualSplit
+	}
+	switch er.Split {
+	case EqualSplit, UnitsSplit:
+	case CustomSplit:
+		var total int64
+		for _, amt := range er.Shares {
+			if amt < 0 {
+				return errors.New("shares cannot be negative")
+			}
+			if amt > er.Amount-total {
+				return errors.New("custom shares must add up to the expense amount")
+			}
+			total += amt
+		}
+		if total != er.Amount {
+			return errors.New("custom shares must add up to the expense amount")
+		}
+	default:
+		return errors.New("expense split should be equal, units or custom")
+	}
+	return nil
+}
+
+// Bind binds the http req to settlementRequest type as the render
+func (sr *SettlementRequest) Bind(r *http.Request) error {
+	sr.Currency = strings.ToUpper(sr.Currency)
+	if sr.Amount <= 0 {
+		return errors.New("settlement amount must be positive")
+	}
+	if len(sr.Currency) != 3 {
+		return errors.New("settlement currency must be a 3 letter code")
+	}
+	if sr.ToID == "" {
+		return errors.New("settlement must have a recipient")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (er *ExpensesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (br *BalancesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+/*splitAmount divides an amount between users in proportion to their weights. Remainder cents go to
+the users with the largest weights first so the shares add up to the amount. Shares are worked out
+with big ints since amount times a weight can overflow */
+func splitAmount(amount int64, users []primitive.ObjectID, weights map[primitive.ObjectID]int64) []ExpenseShare {
+	total := new(big.Int)
+	for _, u := range users {
+		total.Add(total, big.NewInt(weights[u]))
+	}
+	shares := make([]ExpenseShare, 0, len(users))
+	if total.Sign() == 0 {
+		return shares
+	}
+	var assigned int64
+	for _, u := range users {
+		amt := new(big.Int).Mul(big.NewInt(amount), big.NewInt(weights[u]))
+		amt.Quo(amt, total)
+		assigned += amt.Int64()
+		shares = append(shares, ExpenseShare{u, amt.Int64()})
+	}
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return weights[shares[order[i]].UserID] > weights[shares[order[j]].UserID] })
+	for i := 0; assigned < amount; i = (i + 1) % len(order) {
+		if weights[shares[order[i]].UserID] > 0 {
+			shares[order[i]].Amount++
+			assigned++
+		}
+	}
+	return shares
+}
+
+// NewExpense resolves an expense request's split into member shares
+func NewExpense(er ExpenseRequest, g Group, payerID primitive.ObjectID) (*Expense, error) {
+	weights := make(map[primitive.ObjectID]int64)
+	switch er.Split {
+	case EqualSplit:
+		for _, m := range g.Members {
+			weights[m] = 1
+		}
+	case UnitsSplit:
+		resourceID, err := g.resourceID(er.ResourceID)
+		if err != nil {
+			return nil, err
+		}
+		ms := &MasterSchedule{}
+		if err = mh.GetMasterSchedule(ms, masterScheduleFilter(g.ID, resourceID)); err != nil {
+			return nil, err
+		}
+		members, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Members}})
+		if err != nil {
+			return nil, err
+		}
+		ids := make(map[string]primitive.ObjectID)
+		for _, u := range members {
+			ids[u.Email] = u.ID
+		}
+		for _, smu := range ms.ScheduleUnitMap {
+			if id, ok := ids[smu.Owner]; ok {
+				weights[id]++
+			}
+		}
+	case CustomSplit:
+		for uid, amt := range er.Shares {
+			id, err := primitive.ObjectIDFromHex(uid)
+			if err != nil {
+				return nil, err
+			}
+			if !g.HasUser(id) {
+				return nil, errors.New("shares must be owed by group members")
+			}
+			weights[id] = amt
+		}
+	}
+	shares := splitAmount(er.Amount, g.Members, weights)
+	if len(shares) == 0 {
+		return nil, errors.New("no group members to split the expense between")
+	}
+	return &Expense{primitive.NewObjectID(), g.ID, er.Description, er.Amount, er.Currency, payerID, er.Split, shares, time.Now()}, nil
+}
+
+/*NewBalancesResponse nets each member's payments against their shares and settlements, and lists
+the debts that would settle everyone up in each currency */
+func NewBalancesResponse(expenses []*Expense, settlements []*Settlement) *BalancesResponse {
+	net := make(map[string]map[primitive.ObjectID]int64)
+	currency := func(c string) map[primitive.ObjectID]int64 {
+		if _, ok := net[c]; !ok {
+			net[c] = make(map[primitive.ObjectID]int64)
+		}
+		return net[c]
+	}
+	for _, e := range expenses {
+		n := currency(e.Currency)
+		n[e.PayerID] += e.Amount
+		for _, s := range e.Shares {
+			n[s.UserID] -= s.Amount
+		}
+	}
+	for _, s := range settlements {
+		n := currency(s.Currency)
+		n[s.FromID] += s.Amount
+		n[s.ToID] -= s.Amount
+	}
+
+	resp := &BalancesResponse{make(map[string]*CurrencyBalance)}
+	for c, n := range net {
+		cb := &CurrencyBalance{make(map[string]int64), make([]Debt, 0)}
+		type balance struct {
+			id  primitive.ObjectID
+			amt int64
+		}
+		var owed, owing []balance
+		for id, amt := range n {
+			cb.Net[id.Hex()] = amt
+			if amt > 0 {
+				owed = append(owed, balance{id, amt})
+			} else if amt < 0 {
+				owing = append(owing, balance{id, -amt})
+			}
+		}
+		sort.Slice(owed, func(i, j int) bool { return owed[i].amt > owed[j].amt })
+		sort.Slice(owing, func(i, j int) bool { return owing[i].amt > owing[j].amt })
+		// pay the largest debts to the largest creditors first
+		for i, j := 0, 0; i < len(owing) && j < len(owed); {
+			amt := owing[i].amt
+			if owed[j].amt < amt {
+				amt = owed[j].amt
+			}
+			cb.Debts = append(cb.Debts, Debt{owing[i].id, owed[j].id, amt})
+			owing[i].amt -= amt
+			owed[j].amt -= amt
+			if owing[i].amt == 0 {
+				i++
+			}
+			if owed[j].amt == 0 {
+				j++
+			}
+		}
+		resp.Balances[c] = cb
+	}
+	return resp
+}
+
+////////////  CONTROLLERS //////////////////
+
+// groupExpenses gets a group's expenses and settlements
+func groupExpenses(groupID primitive.ObjectID) ([]*Expense, []*Settlement, error) {
+	expenses, err := mh.GetExpenses(bson.M{"groupId": groupID})
+	if err != nil {
+		return nil, nil, err
+	}
+	settlements, err := mh.GetSettlements(bson.M{"groupId": groupID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return expenses, settlements, nil
+}
+
+// GetExpenses lists a group's expenses and settlements
+func GetExpenses(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	expenses, settlements, err := groupExpenses(g.ID)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	resp := &ExpensesResponse{expenses, settlements}
+	if resp.Expenses == nil {
+		resp.Expenses = make([]*Expense, 0)
+	}
+	if resp.Settlements == nil {
+		resp.Settlements = make([]*Settlement, 0)
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
+
+// CreateExpense adds an expense to a group, split between its members
+func CreateExpense(w http.ResponseWriter, r *http.Request) {
+	data := &ExpenseRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, uid := requestGroup(r)
+	payerID := uid
+	if data.PayerID != "" {
+		id, err := primitive.ObjectIDFromHex(data.PayerID)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		if !g.HasUser(id) {
+			render.Render(w, r, ErrInvalidRequest(errors.New("payer must be a group member")))
+			return
+		}
+		payerID = id
+	}
+	e, err := NewExpense(*data, *g, payerID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if _, err = mh.InsertExpense(e); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &ExpensesResponse{[]*Expense{e}, make([]*Settlement, 0)})
+}
+
+// GetBalances shows who owes whom in a group
+func GetBalances(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	expenses, settlements, err := groupExpenses(g.ID)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewBalancesResponse(expenses, settlements))
+}
+
+// SettleUp records a payment between two group members
+func SettleUp(w http.ResponseWriter, r *http.Request) {
+	data := &SettlementRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, uid := requestGroup(r)
+	fromID := uid
+	if data.FromID != "" && data.FromID != uid.Hex() {
+		// only admins record payments made by others
+		if !g.Can(uid, ManageGroup) {
+			render.Render(w, r, ErrForbidden(errors.New("not authorized to record this payment")))
+			return
+		}
+		id, err := primitive.ObjectIDFromHex(data.FromID)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		fromID = id
+	}
+	toID, err := primitive.ObjectIDFromHex(data.ToID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !g.HasUser(fromID) || !g.HasUser(toID) || fromID == toID {
+		render.Render(w, r, ErrInvalidRequest(errors.New("payments must be between two group members")))
+		return
+	}
+	s := &Settlement{primitive.NewObjectID(), g.ID, fromID, toID, data.Amount, data.Currency, time.Now()}
+	if _, err = mh.InsertSettlement(s); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	expenses, settlements, err := groupExpenses(g.ID)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, NewBalancesResponse(expenses, settlements))
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const exportDateLayout = "2006-01-02"
+
+// ExportRow is one flattened unit of a master schedule
+type ExportRow struct {
+	Season       int
+	Block        string
+	UnitID       string
+	Start        time.Time
+	End          time.Time
+	OwnerName    string
+	OwnerEmail   string
+	TradeHistory []string
+	Guests       []string
+}
+
+/*NewExportRows flattens a master schedule's seasons, blocks and units into rows. Unit start and end
+are the check-in and check-out instants in the group's time zone */
+func NewExportRows(ms MasterSchedule, g Group, owners []*User, withTrades bool) []ExportRow {
+
+	names := make(map[string]string)
+	for _, u := range owners {
+		names[u.Email] = strings.TrimSpace(u.FirstName + " " + u.LastName)
+	}
+
+	// executed trades touching each unit, oldest first
+	history := make(map[string][]string)
+	if withTrades {
+		for _, t := range ms.TradeLedger {
+			if t.Status != Executed {
+				continue
+			}
+			for _, tu := range t.InitiatorTrades {
+				uid := tu.ID.String()
+				history[uid] = append(history[uid], t.CreatedAt.Format(exportDateLayout)+" "+t.InitiatorEmail+" -> "+t.ExecutorEmail)
+			}
+			for _, tu := range t.ExecutorTrades {
+				uid := tu.ID.String()
+				history[uid] = append(history[uid], t.CreatedAt.Format(exportDateLayout)+" "+t.ExecutorEmail+" -> "+t.InitiatorEmail)
+			}
+		}
+	}
+
+	var rows []ExportRow
+	for _, s := range ms.Schedule.Seasons {
+		for _, b := range s.Blocks {
+			for _, u := range b.Units {
+				uid := u.ID.String()
+				start, end := g.UnitBounds(u.Start, ms.ScheduleUnitMap[uid].EndDate())
+				rows = append(rows, ExportRow{
+					Season:       s.OpenWeek.Year(),
+					Block:        b.BlockType.String(),
+					UnitID:       uid,
+					Start:        start,
+					End:          end,
+					OwnerName:    names[u.Participant],
+					OwnerEmail:   u.Participant,
+					TradeHistory: history[uid],
+					Guests:       ms.ScheduleUnitMap[uid].guestList(),
+				})
+			}
+		}
+	}
+	return rows
+}
+
+func exportHeader(withTrades bool) []string {
+	header := []string{"season", "block", "unit", "start", "end", "owner", "email"}
+	if withTrades {
+		header = append(header, "trades")
+	}
+	return header
+}
+
+func (er ExportRow) record(withTrades bool) []string {
+	rec := []string{
+		strconv.Itoa(er.Season),
+		er.Block,
+		er.UnitID,
+		er.Start.Format(time.RFC3339),
+		er.End.Format(time.RFC3339),
+		er.OwnerName,
+		er.OwnerEmail,
+	}
+	if withTrades {
+		rec = append(rec, strings.Join(er.TradeHistory, "; "))
+	}
+	return rec
+}
+
+// writeExportCSV writes all rows in a single csv table
+func writeExportCSV(w io.Writer, rows []ExportRow, withTrades bool) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader(withTrades)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := cw.Write(row.record(withTrades)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeExportXLSX writes a workbook with one sheet per season
+func writeExportXLSX(w io.Writer, rows []ExportRow, withTrades bool) error {
+	var sheets []xlsxSheet
+	for _, row := range rows {
+		name := strconv.Itoa(row.Season)
+		if len(sheets) == 0 || sheets[len(sheets)-1].name != name {
+			sheets = append(sheets, xlsxSheet{name, [][]string{exportHeader(withTrades)}})
+		}
+		sheets[len(sheets)-1].rows = append(sheets[len(sheets)-1].rows, row.record(withTrades))
+	}
+	if len(sheets) == 0 {
+		sheets = append(sheets, xlsxSheet{"schedule", [][]string{exportHeader(withTrades)}})
+	}
+	return writeXLSX(w, sheets)
+}
+
+// writeExportICS writes every row as a calendar event at its check-in and check-out instants
+func writeExportICS(w io.Writer, g Group, rows []ExportRow) error {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//jdchai//schedule//EN\r\nCALSCALE:GREGORIAN\r\n")
+	b.WriteString("X-WR-CALNAME:" + icsEscape(g.Name) + "\r\n")
+	b.WriteString("X-WR-TIMEZONE:" + g.Location().String() + "\r\n")
+	stamp := time.Now().UTC().Format(icsTimeLayout)
+	for _, row := range rows {
+		owner := row.OwnerName
+		if owner == "" {
+			owner = row.OwnerEmail
+		}
+		b.WriteString("BEGIN:VEVENT\r\n")
+		b.WriteString("UID:" + row.UnitID + "@jdchai\r\n")
+		b.WriteString("DTSTAMP:" + stamp + "\r\n")
+		b.WriteString("DTSTART:" + row.Start.UTC().Format(icsTimeLayout) + "\r\n")
+		b.WriteString("DTEND:" + row.End.UTC().Format(icsTimeLayout) + "\r\n")
+		if len(row.Guests) > 0 {
+			owner += " (guests: " + strings.Join(row.Guests, ", ") + ")"
+		}
+		b.WriteString("SUMMARY:" + icsEscape(g.Name+": "+owner) + "\r\n")
+		b.WriteString("DESCRIPTION:" + icsEscape(row.descriptionICS()) + "\r\n")
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+const icsTimeLayout = "20060102T150405Z"
+
+func (er ExportRow) descriptionICS() string {
+	desc := er.Block + " week, " + strconv.Itoa(er.Season) + " season. Owner: " + er.OwnerEmail
+	if len(er.TradeHistory) > 0 {
+		desc += "\nTrades: " + strings.Join(er.TradeHistory, "; ")
+	}
+	if len(er.Guests) > 0 {
+		desc += "\nGuests: " + strings.Join(er.Guests, ", ")
+	}
+	return desc
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n").Replace(s)
+}
+
+/////////// XLSX ///////////
+
+// xlsxSheet is a named sheet of string cells
+type xlsxSheet struct {
+	name string
+	rows [][]string
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+%s</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+// writeXLSX writes a minimal spreadsheetml workbook using inline strings
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	zw := zip.NewWriter(w)
+
+	var overrides, wbSheets, wbRels strings.Builder
+	for i := range sheets {
+		n := i + 1
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		fmt.Fprintf(&wbSheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheets[i].name), n, n)
+		fmt.Fprintf(&wbRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	parts := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxContentTypes, overrides.String())},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + wbSheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + wbRels.String() + `</Relationships>`},
+	}
+	for i, s := range sheets {
+		parts = append(parts, struct {
+			name string
+			body string
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxSheetXML(s)})
+	}
+
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func xlsxSheetXML(s xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range s.rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"><is><t>%s</t></is></c>`, xlsxColumn(j), i+1, xmlEscape(cell))
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxColumn converts a zero based column index to its letter reference (0 -> A, 26 -> AA)
+func xlsxColumn(i int) string {
+	col := ""
+	for i >= 0 {
+		col = string(rune('A'+i%26)) + col
+		i = i/26 - 1
+	}
+	return col
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+////////////  CONTROLLERS //////////////////
+
+// ExportMasterSchedule writes the group's current master schedule as a csv, xlsx or ics file
+func ExportMasterSchedule(w http.ResponseWriter, r *http.Request) {
+	g, uid := requestGroup(r)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" && format != "ics" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("format should be csv, xlsx or ics")))
+		return
+	}
+	withTrades, _ := strconv.ParseBool(r.URL.Query().Get("trades"))
+	// observers only get the calendar feed, without trades
+	if role, _ := g.Role(uid); role == ObserverRole {
+		if format != "ics" {
+			render.Render(w, r, ErrForbidden(errors.New("observers can only export the ics calendar")))
+			return
+		}
+		withTrades = false
+	}
+	filter, err := groupScheduleFilter(r, *g)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, filter); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	owners, err := mh.GetUsers(bson.M{"email": bson.M{"$in": ms.ownerEmails()}})
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	rows := NewExportRows(*ms, *g, owners, withTrades)
+
+	// build the file first so a failed export is still a clean error response
+	var file bytes.Buffer
+	contentType := ""
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		err = writeExportCSV(&file, rows, withTrades)
+	case "xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		err = writeExportXLSX(&file, rows, withTrades)
+	case "ics":
+		contentType = "text/calendar; charset=utf-8"
+		err = writeExportICS(&file, *g, rows)
+	}
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	filename := "schedule-" + g.ID.Hex() + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(file.Len()))
+	w.WriteHeader(http.StatusOK)
+	if _, err = file.WriteTo(w); err != nil {
+		log.Println("export " + filename + ": " + err.Error())
+	}
+}
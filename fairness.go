@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	jdscheduler "github.com/ede0m/jdgoscheduler"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fairness goals for schedule generation
+const (
+	NoFairnessGoal       = "none"
+	BalancedFairnessGoal = "balanced"
+)
+
+// maxBalanceSwaps bounds the unit swaps made while balancing a generated schedule
+const maxBalanceSwaps = 1000
+
+// Kinds of weighted date ranges
+const (
+	HolidayRange = "holiday"
+	PeakRange    = "peak"
+)
+
+// DateRange is an inclusive range of days. Recurring ranges match the same days every year
+type DateRange struct {
+	Start     time.Time `json:"start" bson:"start"`
+	End       time.Time `json:"end" bson:"end"`
+	Recurring bool      `json:"recurring" bson:"recurring"`
+}
+
+// WeightedRange gives the units in a date range a value weight
+type WeightedRange struct {
+	DateRange `bson:",inline"`
+	Label     string  `json:"label" bson:"label"`
+	Kind      string  `json:"kind" bson:"kind"` // holiday, peak or empty
+	Weight    float64 `json:"weight" bson:"weight"`
+}
+
+// OwnerUnitsSummary is a count of units and their value
+type OwnerUnitsSummary struct {
+	Units int     `json:"units"`
+	Score float64 `json:"score"`
+}
+
+// OwnerReport is the share of a master schedule one owner holds
+type OwnerReport struct {
+	Owner        string            `json:"owner"`
+	Units        int               `json:"units"`
+	Months       map[string]int    `json:"months"`
+	Seasons      map[int]int       `json:"seasons"`
+	HolidayUnits int               `json:"holidayUnits"`
+	PeakUnits    int               `json:"peakUnits"`
+	Score        float64           `json:"score"`
+	Original     OwnerUnitsSummary `json:"original"` // before executed trades
+	TradedIn     int               `json:"tradedIn"`
+	TradedOut    int               `json:"tradedOut"`
+	ScoreDelta   float64           `json:"scoreDelta"`
+}
+
+// FairnessReportRequest configures the weights used to value units
+type FairnessReportRequest struct {
+	Weights []WeightedRange `json:"weights"`
+}
+
+// FairnessReportResponse is the per owner value report of a master schedule
+type FairnessReportResponse struct {
+	ScheduleID     primitive.ObjectID `json:"scheduleId"`
+	Owners         []OwnerReport      `json:"owners"`
+	Spread         float64            `json:"spread"`         // highest minus lowest owner score
+	OriginalSpread float64            `json:"originalSpread"` // spread before executed trades
+}
+
+// Contains checks whether a day falls in the range
+func (dr DateRange) Contains(t time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(dr.Start.Year(), dr.Start.Month(), dr.Start.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(dr.End.Year(), dr.End.Month(), dr.End.Day(), 0, 0, 0, 0, time.UTC)
+	if !dr.Recurring {
+		return !day.Before(start) && !day.After(end)
+	}
+	// compare month and day only, ranges may wrap the new year
+	md := func(t time.Time) int { return int(t.Month())*100 + t.Day() }
+	d, s, e := md(day), md(start), md(end)
+	if s <= e {
+		return s <= d && d <= e
+	}
+	return d >= s || d <= e
+}
+
+// Overlaps checks whether any day of [start, end) falls in the range
+func (dr DateRange) Overlaps(start, end time.Time) bool {
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if dr.Contains(d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dr DateRange) validate() error {
+	if dr.Start.IsZero() || dr.End.IsZero() {
+		return errors.New("date range must have a start and end")
+	}
+	if !dr.Recurring && dr.End.Before(dr.Start) {
+		return errors.New("date range must end after it starts")
+	}
+	return nil
+}
+
+/*unitValue is the weight of the highest weighted range a unit overlaps, 1 otherwise.
+It also returns the kinds of range the unit overlaps. */
+func unitValue(start time.Time, weights []WeightedRange) (float64, map[string]bool) {
+	value := 1.0
+	matched := false
+	kinds := make(map[string]bool)
+	end := start.AddDate(0, 0, unitDays)
+	for _, wr := range weights {
+		if !wr.Overlaps(start, end) {
+			continue
+		}
+		kinds[wr.Kind] = true
+		if !matched || wr.Weight > value {
+			value = wr.Weight
+			matched = true
+		}
+	}
+	return value, kinds
+}
+
+/*originalOwners maps each unit to its owner before any executed trade. The first executed trade
+a unit appears in tells who gave it away. */
+func (ms *MasterSchedule) originalOwners() map[string]string {
+	trades := make([]Trade, 0)
+	for _, t := range ms.TradeLedger {
+		if t.Status == Executed {
+			trades = append(trades, t)
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].CreatedAt.Before(trades[j].CreatedAt) })
+
+	owners := make(map[string]string)
+	for _, t := range trades {
+		for _, tu := range t.InitiatorTrades {
+			if _, ok := owners[tu.ID.String()]; !ok {
+				owners[tu.ID.String()] = t.InitiatorEmail
+			}
+		}
+		for _, tu := range t.ExecutorTrades {
+			if _, ok := owners[tu.ID.String()]; !ok {
+				owners[tu.ID.String()] = t.ExecutorEmail
+			}
+		}
+	}
+	for id, smu := range ms.ScheduleUnitMap {
+		if _, ok := owners[id]; !ok {
+			owners[id] = smu.Owner
+		}
+	}
+	return owners
+}
+
+// NewFairnessReport values each owner's units in a master schedule with the given weights
+func NewFairnessReport(ms MasterSchedule, weights []WeightedRange) *FairnessReportResponse {
+
+	reports := make(map[string]*OwnerReport)
+	owner := func(email string) *OwnerReport {
+		if or, ok := reports[email]; ok {
+			return or
+		}
+		or := &OwnerReport{Owner: email, Months: make(map[string]int), Seasons: make(map[int]int)}
+		reports[email] = or
+		return or
+	}
+	seasonOf := func(idx []int) int {
+		if len(idx) == 3 && idx[0] < len(ms.Schedule.Seasons) {
+			return ms.Schedule.Seasons[idx[0]].OpenWeek.Year()
+		}
+		return 0
+	}
+
+	original := ms.originalOwners()
+	for id, smu := range ms.ScheduleUnitMap {
+		if smu.Owner == "" {
+			continue
+		}
+		value, kinds := unitValue(smu.Start, weights)
+		// parts of a split unit carry their share of its value
+		value *= smu.EndDate().Sub(smu.Start).Hours() / (24 * unitDays)
+
+		or := owner(smu.Owner)
+		or.Units++
+		or.Score += value
+		or.Months[smu.Start.Month().String()]++
+		or.Seasons[seasonOf(smu.MapIndicies)]++
+		if kinds[HolidayRange] {
+			or.HolidayUnits++
+		}
+		if kinds[PeakRange] {
+			or.PeakUnits++
+		}
+
+		if orig := original[id]; orig != smu.Owner && orig != "" {
+			or.TradedIn++
+			oo := owner(orig)
+			oo.TradedOut++
+			oo.Original.Units++
+			oo.Original.Score += value
+		} else {
+			or.Original.Units++
+			or.Original.Score += value
+		}
+	}
+
+	resp := &FairnessReportResponse{ScheduleID: ms.ID, Owners: make([]OwnerReport, 0)}
+	for _, or := range reports {
+		or.ScoreDelta = or.Score - or.Original.Score
+		resp.Owners = append(resp.Owners, *or)
+	}
+	sort.Slice(resp.Owners, func(i, j int) bool {
+		if resp.Owners[i].Score == resp.Owners[j].Score {
+			return resp.Owners[i].Owner < resp.Owners[j].Owner
+		}
+		return resp.Owners[i].Score > resp.Owners[j].Score
+	})
+	resp.Spread = spread(resp.Owners, func(or OwnerReport) float64 { return or.Score })
+	resp.OriginalSpread = spread(resp.Owners, func(or OwnerReport) float64 { return or.Original.Score })
+	return resp
+}
+
+func spread(owners []OwnerReport, score func(OwnerReport) float64) float64 {
+	if len(owners) == 0 {
+		return 0
+	}
+	min, max := score(owners[0]), score(owners[0])
+	for _, or := range owners {
+		if s := score(or); s < min {
+			min = s
+		} else if s > max {
+			max = s
+		}
+	}
+	return max - min
+}
+
+// ParticipantScores sums the unit values of each participant in a schedule
+func ParticipantScores(sch jdscheduler.Schedule, weights []WeightedRange) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, p := range sch.Participants {
+		scores[p] = 0
+	}
+	for _, s := range sch.Seasons {
+		for _, b := range s.Blocks {
+			for _, u := range b.Units {
+				v, _ := unitValue(u.Start, weights)
+				scores[u.Participant] += v
+			}
+		}
+	}
+	return scores
+}
+
+/*BalanceSchedule evens out participant scores by swapping units between the highest and lowest
+scoring participants. Swaps stay within a season so everyone keeps the same number of units
+each year. */
+func BalanceSchedule(sch *jdscheduler.Schedule, weights []WeightedRange) {
+	if len(sch.Participants) < 2 {
+		return
+	}
+	for i := 0; i < maxBalanceSwaps; i++ {
+		scores := ParticipantScores(*sch, weights)
+		hi, lo := sch.Participants[0], sch.Participants[0]
+		for _, p := range sch.Participants {
+			if scores[p] > scores[hi] {
+				hi = p
+			}
+			if scores[p] < scores[lo] {
+				lo = p
+			}
+		}
+		gap := scores[hi] - scores[lo]
+
+		// the swap that leaves the smallest gap between hi and lo
+		var a, b *jdscheduler.Unit
+		best := 0.0
+		for _, s := range sch.Seasons {
+			for x := range s.Blocks {
+				for y := range s.Blocks[x].Units {
+					ua := &s.Blocks[x].Units[y]
+					if ua.Participant != hi {
+						continue
+					}
+					va, _ := unitValue(ua.Start, weights)
+					for m := range s.Blocks {
+						for n := range s.Blocks[m].Units {
+							ub := &s.Blocks[m].Units[n]
+							if ub.Participant != lo {
+								continue
+							}
+							vb, _ := unitValue(ub.Start, weights)
+							d := va - vb
+							improvement := gap - math.Abs(gap-2*d)
+							if d > 0 && improvement > best+1e-9 {
+								a, b, best = ua, ub, improvement
+							}
+						}
+					}
+				}
+			}
+		}
+		if a == nil {
+			return
+		}
+		a.Participant, b.Participant = lo, hi
+	}
+}
+
+// Bind binds the http req to fairnessReportRequest type as the render
+func (frr *FairnessReportRequest) Bind(r *http.Request) error {
+	return validateWeights(frr.Weights)
+}
+
+func validateWeights(weights []WeightedRange) error {
+	for _, wr := range weights {
+		if err := wr.validate(); err != nil {
+			return err
+		}
+		if wr.Weight < 0 {
+			return errors.New("weights cannot be negative")
+		}
+		if wr.Kind != "" && wr.Kind != HolidayRange && wr.Kind != PeakRange {
+			return errors.New("weight kind should be holiday, peak or empty")
+		}
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (fr *FairnessReportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetFairnessReport reports how the value of a group's current master schedule is split between owners
+func GetFairnessReport(w http.ResponseWriter, r *http.Request) {
+	data := &FairnessReportRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	filter, err := groupScheduleFilter(r, *g)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, filter); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewFairnessReport(*ms, data.Weights))
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	jdchaimailer "github.com/ede0m/jdchai/mailer"
@@ -15,18 +17,47 @@ import (
 
 // Group defines a group for a scheudle
 type Group struct {
-	ID      primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	Name    string               `json:"name" bson:"name"`
-	Admins  []primitive.ObjectID `json:"admins" bson:"admins"`
-	Members []primitive.ObjectID `json:"members" bson:"members"`
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name        string               `json:"name" bson:"name"`
+	Admins      []primitive.ObjectID `json:"admins" bson:"admins"`
+	Members     []primitive.ObjectID `json:"members" bson:"members"`
+	Blackouts   []Blackout           `json:"blackouts" bson:"blackouts,omitempty"`
+	TimeZone    string               `json:"timeZone" bson:"timeZone,omitempty"` // IANA zone of the property
+	CheckIn     string               `json:"checkIn" bson:"checkIn,omitempty"`   // time of day, 15:04
+	CheckOut    string               `json:"checkOut" bson:"checkOut,omitempty"` // time of day, 15:04
+	Resources   []Resource           `json:"resources" bson:"resources,omitempty"`
+	OwnerID     primitive.ObjectID   `json:"ownerId" bson:"ownerId,omitempty"`
+	MaxAdmins   int                  `json:"maxAdmins" bson:"maxAdmins,omitempty"`
+	DeletedAt   time.Time            `json:"deletedAt" bson:"deletedAt,omitempty"`
+	ArchivedFor []primitive.ObjectID `json:"-" bson:"archivedFor,omitempty"` // users the group was pulled from when deleted
+	Roles       map[string]Role      `json:"roles" bson:"roles,omitempty"`   // scheduler and viewer members by user id
+	Observers   []primitive.ObjectID `json:"observers" bson:"observers,omitempty"`
 }
 
+// groupRestoreWindow is how long a deleted group can be restored
+const groupRestoreWindow = 30 * 24 * time.Hour
+
+// Group defaults for unit boundaries
+const (
+	DefaultTimeZone = "UTC"
+	DefaultCheckIn  = "15:00"
+	DefaultCheckOut = "11:00"
+	timeOfDayLayout = "15:04"
+)
+
+// DefaultMaxAdmins is the admin cap of groups that have not set their own
+const DefaultMaxAdmins = 5
+
 // GroupRequest is a request to create a new group
 type GroupRequest struct {
 	Name         string               `json:"name"`
 	AdminEmails  []string             `json:"adminEmails"`
 	MemberEmails []string             `json:"memberEmails"`
 	Schedule     jdscheduler.Schedule `json:"schedule"`
+	TimeZone     string               `json:"timeZone"`
+	CheckIn      string               `json:"checkIn"`
+	CheckOut     string               `json:"checkOut"`
+	MaxAdmins    int                  `json:"maxAdmins"`
 }
 
 // GroupResponse is a client response of a group
@@ -36,9 +67,24 @@ type GroupResponse struct {
 	NParticipants int                `json:"nParticipants"`
 }
 
+// GroupSettingsRequest is a request by a group admin to rename a group or change its settings. Omitted fields are unchanged
+type GroupSettingsRequest struct {
+	Name      *string `json:"name"`
+	TimeZone  *string `json:"timeZone"`
+	CheckIn   *string `json:"checkIn"`
+	CheckOut  *string `json:"checkOut"`
+	MaxAdmins *int    `json:"maxAdmins"`
+}
+
+// GroupDetailResponse is a client response of a group with all its settings
+type GroupDetailResponse struct {
+	Group
+}
+
 // GroupUsersResponse response for all users in a group
 type GroupUsersResponse struct {
-	Members []GroupUserResponse `json:"members"`
+	Members   []GroupUserResponse `json:"members"`
+	Observers []GroupUserResponse `json:"observers"`
 }
 
 // NewGroup creates a group with admins. will check that every listed admin exists
@@ -58,13 +104,19 @@ func NewGroup(gr GroupRequest) (*Group, error) {
 		return nil, errors.New("group name: " + gr.Name + " aready exists")
 	}
 
+	// the first listed admin owns the group
 	adminIds := make([]primitive.ObjectID, 0)
+	ownerID := primitive.NilObjectID
 	for _, u := range foundUsers {
 		adminIds = append(adminIds, u.ID)
+		if strings.EqualFold(u.Email, gr.AdminEmails[0]) {
+			ownerID = u.ID
+		}
 	}
 	// members empty initially because we may need to create new users
 	memberIds := make([]primitive.ObjectID, 0)
-	group := &Group{primitive.NilObjectID, gr.Name, adminIds, memberIds}
+	group := &Group{ID: primitive.NilObjectID, Name: gr.Name, Admins: adminIds, Members: memberIds,
+		TimeZone: gr.TimeZone, CheckIn: gr.CheckIn, CheckOut: gr.CheckOut, OwnerID: ownerID, MaxAdmins: gr.MaxAdmins}
 	return group, nil
 }
 
@@ -73,13 +125,17 @@ func NewGroupResponse(g Group) *GroupResponse {
 	return &GroupResponse{g.ID, g.Name, len(g.Members)}
 }
 
-// NewGroupUsersResponse groupUser representation from user slice
-func NewGroupUsersResponse(users []*User) *GroupUsersResponse {
+// NewGroupUsersResponse groupUser representation from member and observer slices
+func NewGroupUsersResponse(users []*User, observers []*User) *GroupUsersResponse {
 	var groupUsers []GroupUserResponse
 	for _, u := range users {
 		groupUsers = append(groupUsers, *NewGroupUserResponse(*u))
 	}
-	return &GroupUsersResponse{groupUsers}
+	groupObservers := make([]GroupUserResponse, 0, len(observers))
+	for _, u := range observers {
+		groupObservers = append(groupObservers, *NewGroupUserResponse(*u))
+	}
+	return &GroupUsersResponse{groupUsers, groupObservers}
 }
 
 // Render is called in top-down order, like a http handler middleware chain.
@@ -95,10 +151,15 @@ func (gur *GroupUsersResponse) Render(w http.ResponseWriter, r *http.Request) er
 // Bind binds the http req to groupRequest type as the render
 func (gr *GroupRequest) Bind(r *http.Request) error {
 
+	if gr.MaxAdmins == 0 {
+		gr.MaxAdmins = DefaultMaxAdmins
+	}
 	if gr.Name == "" {
 		return errors.New("group must have a name")
-	} else if len(gr.AdminEmails) > 5 {
-		return errors.New("cannot have more than 5 admins")
+	} else if gr.MaxAdmins < 1 {
+		return errors.New("groups must allow at least one admin")
+	} else if len(gr.AdminEmails) > gr.MaxAdmins {
+		return errors.New("cannot have more than " + strconv.Itoa(gr.MaxAdmins) + " admins")
 	} else if len(gr.AdminEmails) == 0 {
 		return errors.New("must have at least one admin")
 	} else if len(gr.MemberEmails) == 0 {
@@ -106,6 +167,45 @@ func (gr *GroupRequest) Bind(r *http.Request) error {
 	} else if len(gr.Schedule.Participants) == 0 {
 		return errors.New("must submit with valid schedule")
 	}
+	if gr.TimeZone == "" {
+		gr.TimeZone = DefaultTimeZone
+	}
+	if gr.CheckIn == "" {
+		gr.CheckIn = DefaultCheckIn
+	}
+	if gr.CheckOut == "" {
+		gr.CheckOut = DefaultCheckOut
+	}
+	return validateGroupTimes(gr.TimeZone, gr.CheckIn, gr.CheckOut)
+}
+
+// Bind binds the http req to groupSettingsRequest type as the render
+func (gsr *GroupSettingsRequest) Bind(r *http.Request) error {
+	if gsr.Name != nil && *gsr.Name == "" {
+		return errors.New("group must have a name")
+	}
+	if gsr.MaxAdmins != nil && *gsr.MaxAdmins < 1 {
+		return errors.New("groups must allow at least one admin")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (gdr *GroupDetailResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// validateGroupTimes checks a group's time zone and check-in/check-out times of day
+func validateGroupTimes(tz, checkIn, checkOut string) error {
+	if _, err := time.LoadLocation(tz); err != nil {
+		return errors.New("unknown time zone " + tz)
+	}
+	if _, err := time.Parse(timeOfDayLayout, checkIn); err != nil {
+		return errors.New("check-in must be a time of day formatted " + timeOfDayLayout)
+	}
+	if _, err := time.Parse(timeOfDayLayout, checkOut); err != nil {
+		return errors.New("check-out must be a time of day formatted " + timeOfDayLayout)
+	}
 	return nil
 }
 
@@ -113,27 +213,26 @@ func (gr *GroupRequest) Bind(r *http.Request) error {
 
 // GetGroupUsers gets users in a group
 func GetGroupUsers(w http.ResponseWriter, r *http.Request) {
-	gid := chi.URLParam(r, "groupID")
-	groupID, err := primitive.ObjectIDFromHex(gid)
-	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
-		return
-	}
-	g := &Group{}
-	err = mh.GetGroup(g, bson.M{"_id": groupID})
+	g, _ := requestGroup(r)
+	users, err := findUsers(bson.M{"_id": bson.M{"$in": g.Members}})
 	if err != nil {
 		render.Render(w, r, ErrNotFound(err))
 		return
 	}
-	users, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Members}})
-	if err != nil {
-		render.Render(w, r, ErrNotFound(err))
-		return
+	observers := make([]*User, 0)
+	if len(g.Observers) > 0 {
+		observers, err = findUsers(bson.M{"_id": bson.M{"$in": g.Observers}})
+		if err != nil {
+			render.Render(w, r, ErrNotFound(err))
+			return
+		}
 	}
-	render.Status(r, http.StatusCreated)
-	render.Render(w, r, NewGroupUsersResponse(users))
+	render.Render(w, r, NewGroupUsersResponse(users, observers))
 }
 
+// findUsers loads the users of a group, a variable so the store can be stubbed
+var findUsers = func(filter interface{}) ([]*User, error) { return mh.GetUsers(filter) }
+
 // CreateGroup creates a new group
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
 
@@ -212,6 +311,33 @@ func (g Group) HasAdmin(uid primitive.ObjectID) bool {
 	return false
 }
 
+// HasObserver checks whether or not a user is an observer of a group
+func (g Group) HasObserver(uid primitive.ObjectID) bool {
+	for _, u := range g.Observers {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParticipants makes sure none of a schedule's participants are observers of the group
+func (g Group) checkParticipants(participants []string) error {
+	if len(g.Observers) == 0 {
+		return nil
+	}
+	observers, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Observers}})
+	if err != nil {
+		return err
+	}
+	for _, o := range observers {
+		if indexOf(o.Email, participants) >= 0 {
+			return errors.New("observer " + o.Email + " cannot be a schedule participant")
+		}
+	}
+	return nil
+}
+
 // HasUser checks whether or not a user is admin of a group
 func (g Group) HasUser(uid primitive.ObjectID) bool {
 	for _, u := range g.Members {
@@ -221,3 +347,158 @@ func (g Group) HasUser(uid primitive.ObjectID) bool {
 	}
 	return false
 }
+
+// Owner is the admin that owns the group. Groups made before owners were added are owned by their first admin
+func (g Group) Owner() primitive.ObjectID {
+	if !g.OwnerID.IsZero() || len(g.Admins) == 0 {
+		return g.OwnerID
+	}
+	return g.Admins[0]
+}
+
+// AdminLimit is the most admins the group can have
+func (g Group) AdminLimit() int {
+	if g.MaxAdmins == 0 {
+		return DefaultMaxAdmins
+	}
+	return g.MaxAdmins
+}
+
+// Location is the group's time zone
+func (g Group) Location() *time.Location {
+	if g.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(g.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+/*UnitBounds converts a unit's start and end days into the check-in and check-out instants
+in the group's time zone */
+func (g Group) UnitBounds(start, end time.Time) (time.Time, time.Time) {
+	return g.atTimeOfDay(start, g.CheckIn, DefaultCheckIn), g.atTimeOfDay(end, g.CheckOut, DefaultCheckOut)
+}
+
+func (g Group) atTimeOfDay(day time.Time, tod, def string) time.Time {
+	t, err := time.Parse(timeOfDayLayout, tod)
+	if err != nil {
+		t, _ = time.Parse(timeOfDayLayout, def)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, g.Location())
+}
+
+// GetGroupDetails gets a group with its settings
+func GetGroupDetails(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &GroupDetailResponse{*g})
+}
+
+// UpdateGroupSettings renames a group or changes its time zone and check-in/check-out times
+func UpdateGroupSettings(w http.ResponseWriter, r *http.Request) {
+	data := &GroupSettingsRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	set := bson.M{}
+	if data.Name != nil && *data.Name != g.Name {
+		if err := mh.GetGroup(&Group{}, bson.M{"name": *data.Name}); err == nil {
+			render.Render(w, r, ErrConflict(errors.New("group name: "+*data.Name+" aready exists")))
+			return
+		}
+		g.Name = *data.Name
+		set["name"] = g.Name
+	}
+	// groups made before time zones were added use the defaults
+	if g.TimeZone == "" {
+		g.TimeZone = DefaultTimeZone
+	}
+	if g.CheckIn == "" {
+		g.CheckIn = DefaultCheckIn
+	}
+	if g.CheckOut == "" {
+		g.CheckOut = DefaultCheckOut
+	}
+	if data.TimeZone != nil {
+		g.TimeZone = *data.TimeZone
+		set["timeZone"] = g.TimeZone
+	}
+	if data.CheckIn != nil {
+		g.CheckIn = *data.CheckIn
+		set["checkIn"] = g.CheckIn
+	}
+	if data.CheckOut != nil {
+		g.CheckOut = *data.CheckOut
+		set["checkOut"] = g.CheckOut
+	}
+	if err := validateGroupTimes(g.TimeZone, g.CheckIn, g.CheckOut); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if data.MaxAdmins != nil {
+		if *data.MaxAdmins < len(g.Admins) {
+			render.Render(w, r, ErrInvalidRequest(errors.New("group already has more admins than "+strconv.Itoa(*data.MaxAdmins))))
+			return
+		}
+		g.MaxAdmins = *data.MaxAdmins
+		set["maxAdmins"] = g.MaxAdmins
+	}
+	if len(set) > 0 {
+		if err := mh.UpdateGroup(bson.M{"_id": g.ID}, bson.M{"$set": set}); err != nil {
+			render.Render(w, r, ErrServer(err))
+			return
+		}
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &GroupDetailResponse{*g})
+}
+
+/*DeleteGroup archives a group with its schedules and trades and pulls it from its users' groups.
+It can be restored for 30 days */
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	if err := mh.ArchiveGroup(g.ID, time.Now()); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// RestoreGroup undoes the deletion of a group within 30 days
+func RestoreGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "groupID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g := &Group{}
+	if err = mh.GetDeletedGroup(g, bson.M{"_id": groupID}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	// deleted groups are not found by Authorize
+	if !g.Can(requestUserID(r), OwnGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("not authorized for this group")))
+		return
+	}
+	if time.Since(g.DeletedAt) > groupRestoreWindow {
+		render.Render(w, r, ErrInvalidRequest(errors.New("groups can only be restored for 30 days after deletion")))
+		return
+	}
+	if err = mh.GetGroup(&Group{}, bson.M{"name": g.Name}); err == nil {
+		render.Render(w, r, ErrConflict(errors.New("group name: "+g.Name+" has been taken by another group")))
+		return
+	}
+	if err = mh.RestoreGroup(g); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	g.DeletedAt = time.Time{}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &GroupDetailResponse{*g})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGroupUsersWithoutObservers(t *testing.T) {
+	h, a, _ := crossGroupRouter(t)
+	stored := findUsers
+	findUsers = func(filter interface{}) ([]*User, error) {
+		ids, ok := filter.(bson.M)["_id"].(bson.M)["$in"].([]primitive.ObjectID)
+		if !ok || ids == nil {
+			return nil, errors.New("$in needs an array")
+		}
+		users := make([]*User, 0, len(ids))
+		for _, id := range ids {
+			users = append(users, &User{ID: id, Email: id.Hex() + "@example.com"})
+		}
+		return users, nil
+	}
+	t.Cleanup(func() { findUsers = stored })
+
+	w := requestAs(h, a.Members[0], http.MethodGet, "/group/"+a.ID.Hex()+"/user")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET group users with no observers: got %d, want 200", w.Code)
+	}
+	var res GroupUsersResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Members) != 1 || res.Observers == nil || len(res.Observers) != 0 {
+		t.Errorf("got %d users and observers %v, want 1 user and no observers", len(res.Members), res.Observers)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jdscheduler "github.com/ede0m/jdgoscheduler"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seasonGapDays is the break between units that starts a new season when rows do not name their season
+const seasonGapDays = 8 * unitDays
+
+// maxImportSize bounds imported schedule uploads
+const maxImportSize = 10 << 20
+
+// ImportRow is a parsed (start date, owner email, season) row of an imported schedule
+type ImportRow struct {
+	Line   int
+	Start  time.Time
+	Owner  string
+	Season string // optional label, e.g. 2019-2020 for a ski season spanning new year
+}
+
+// ImportRowError reports why a row of an imported schedule was rejected
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportScheduleResponse reports the outcome of a schedule import
+type ImportScheduleResponse struct {
+	DryRun   bool                    `json:"dryRun"`
+	Rows     int                     `json:"rows"`
+	Errors   []ImportRowError        `json:"errors"`
+	Schedule *MasterScheduleResponse `json:"schedule,omitempty"`
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (isr *ImportScheduleResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// parseImportRows reads csv (start date, owner email, season) rows. The season is optional. A leading header row is skipped
+func parseImportRows(in io.Reader) ([]ImportRow, []ImportRowError, error) {
+	cr := csv.NewReader(in)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows []ImportRow
+	var rowErrs []ImportRowError
+	line := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rec) < 2 {
+			rowErrs = append(rowErrs, ImportRowError{line, "expected start date and owner email"})
+			continue
+		}
+		start, err := time.Parse(exportDateLayout, strings.TrimSpace(rec[0]))
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			rowErrs = append(rowErrs, ImportRowError{line, "start date must be formatted " + exportDateLayout})
+			continue
+		}
+		owner := strings.ToLower(strings.TrimSpace(rec[1]))
+		if owner == "" {
+			rowErrs = append(rowErrs, ImportRowError{line, "missing owner email"})
+			continue
+		}
+		season := ""
+		if len(rec) > 2 {
+			season = strings.TrimSpace(rec[2])
+		}
+		rows = append(rows, ImportRow{line, start, owner, season})
+	}
+	return rows, rowErrs, nil
+}
+
+/*NewImportedSchedule validates rows against the group's member emails and blackouts and builds a schedule.
+Rows are grouped into seasons by their season label or, without one, by breaks of more than
+seasonGapDays between units. Units are whole weeks and may not overlap */
+func NewImportedSchedule(rows []ImportRow, memberEmails []string, blackouts []DateRange) (*jdscheduler.Schedule, []ImportRowError) {
+
+	// match case insensitively but keep the stored address
+	members := make(map[string]string)
+	for _, e := range memberEmails {
+		members[strings.ToLower(e)] = e
+	}
+
+	var rowErrs []ImportRowError
+	var valid []ImportRow
+	for _, row := range rows {
+		email, ok := members[row.Owner]
+		if !ok {
+			rowErrs = append(rowErrs, ImportRowError{row.Line, row.Owner + " is not a member of the group"})
+			continue
+		}
+		if inBlackout(row.Start, row.Start.AddDate(0, 0, unitDays), blackouts) {
+			rowErrs = append(rowErrs, ImportRowError{row.Line, "unit is in a blackout of the group"})
+			continue
+		}
+		row.Owner = email
+		valid = append(valid, row)
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].Start.Before(valid[j].Start) })
+
+	sch := &jdscheduler.Schedule{}
+	seen := make(map[string]bool)
+	var season *jdscheduler.Season
+	label := ""
+	labels := make(map[string]bool)
+	var prev *ImportRow
+	for i := range valid {
+		row := valid[i]
+		if prev != nil && row.Start.Before(prev.Start.AddDate(0, 0, 7)) {
+			rowErrs = append(rowErrs, ImportRowError{row.Line, "unit overlaps unit on line " + strconv.Itoa(prev.Line)})
+			continue
+		}
+		newSeason := season == nil
+		if !newSeason {
+			if row.Season != "" || label != "" {
+				newSeason = row.Season != label
+			} else {
+				newSeason = row.Start.After(season.CloseWeek.AddDate(0, 0, unitDays+seasonGapDays))
+			}
+		}
+		if newSeason && row.Season != "" && labels[row.Season] {
+			rowErrs = append(rowErrs, ImportRowError{row.Line, "units of season " + row.Season + " must be consecutive"})
+			continue
+		}
+		if newSeason {
+			season = &jdscheduler.Season{OpenWeek: row.Start, Blocks: []jdscheduler.Block{{BlockType: jdscheduler.None}}}
+			sch.Seasons = append(sch.Seasons, season)
+			label = row.Season
+			labels[label] = true
+		}
+		season.CloseWeek = row.Start
+		season.Blocks[0].Units = append(season.Blocks[0].Units, jdscheduler.Unit{ID: uuid.New(), Start: row.Start, Participant: row.Owner})
+		if !seen[row.Owner] {
+			seen[row.Owner] = true
+			sch.Participants = append(sch.Participants, row.Owner)
+		}
+		prev = &valid[i]
+	}
+
+	sch.Years = len(sch.Seasons)
+	for _, s := range sch.Seasons {
+		if n := len(s.Blocks[0].Units); n > sch.UnitsPerSeason {
+			sch.UnitsPerSeason = n
+		}
+	}
+	return sch, rowErrs
+}
+
+////////////  CONTROLLERS //////////////////
+
+/*ImportMasterSchedule stores a csv of (start date, owner email, season) rows as the group's new master schedule.
+With ?dryRun=true nothing is stored and the row level errors are reported */
+func ImportMasterSchedule(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	resourceID, err := g.resourceID(r.URL.Query().Get("resource"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	// accept a multipart upload or a raw csv body
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var in io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+	rows, rowErrs, err := parseImportRows(in)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+
+	members, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Members}})
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	var memberEmails []string
+	for _, u := range members {
+		memberEmails = append(memberEmails, u.Email)
+	}
+	sch, schErrs := NewImportedSchedule(rows, memberEmails, g.blackoutRanges(resourceID))
+	rowErrs = append(rowErrs, schErrs...)
+	sort.SliceStable(rowErrs, func(i, j int) bool { return rowErrs[i].Line < rowErrs[j].Line })
+
+	resp := &ImportScheduleResponse{DryRun: dryRun, Rows: len(rows), Errors: rowErrs}
+	if len(rowErrs) == 0 && len(sch.Seasons) == 0 {
+		resp.Errors = append(resp.Errors, ImportRowError{0, "no schedule units found"})
+	}
+	ms, err := NewMasterSchedule(*sch, g.ID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms.ResourceID = resourceID
+	if dryRun {
+		resp.Schedule = NewMasterScheduleResponse(*ms, *g)
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, resp)
+		return
+	}
+	if len(resp.Errors) > 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.Render(w, r, resp)
+		return
+	}
+	result, err := mh.InsertMasterSchedule(ms)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	ms.ID = result.InsertedID.(primitive.ObjectID)
+	resp.Schedule = NewMasterScheduleResponse(*ms, *g)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, resp)
+}
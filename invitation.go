@@ -6,7 +6,6 @@ import (
 	"time"
 
 	jdchaimailer "github.com/ede0m/jdchai/mailer"
-	"github.com/go-chi/jwtauth"
 	"github.com/go-chi/render"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,8 +14,9 @@ import (
 
 // InviteRequest is a request by a group admin to create users in a group and "invite" them to the system
 type InviteRequest struct {
-	GroupID      string   `json:"groupId"`
-	MemberEmails []string `json:"memberEmails"`
+	GroupID        string   `json:"groupId"`
+	MemberEmails   []string `json:"memberEmails"`
+	ObserverEmails []string `json:"observerEmails"` // read-only users that see the schedule but own no units
 }
 
 // AcceptRegisterInviteRequest is a request by an invitee to sign up in the system and group
@@ -38,8 +38,8 @@ func (si *InviteRequest) Bind(r *http.Request) error {
 	if si.GroupID == "" {
 		return errors.New("must specify group id to send system invite")
 	}
-	if len(si.MemberEmails) == 0 {
-		return errors.New("must have at least one member")
+	if len(si.MemberEmails) == 0 && len(si.ObserverEmails) == 0 {
+		return errors.New("must have at least one member or observer")
 	}
 	return nil
 }
@@ -78,6 +78,38 @@ func (ir *AcceptRegisterInviteResponse) Render(w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+/*inviteUser creates or updates the account of an invitee with the group. It will fire off an email
+depending on the user's current system status */
+func inviteUser(g Group, email string) (primitive.ObjectID, render.Renderer) {
+	// TODO: verify email
+	rr := RegisterRequest{"", "", email, "password", g.ID}
+	u, err := NewUser(rr)
+	if err != nil {
+		if u == nil {
+			return primitive.NilObjectID, ErrInvalidRequest(err)
+		}
+		// user exists
+		if !u.inGroup(g.ID) {
+			update := bson.M{"$addToSet": bson.M{"groups": g.ID}}
+			if err := mh.UpdateUsers(bson.M{"_id": u.ID}, update); err != nil {
+				return primitive.NilObjectID, ErrServer(err)
+			}
+			go jdchaimailer.SendGroupInvite(g.Name, u.FirstName, u.Email)
+		}
+		return u.ID, nil
+	}
+	// user not in system, so we create and send welcome registration
+	result, err := mh.InsertUser(u)
+	if err != nil {
+		return primitive.NilObjectID, ErrServer(err)
+	}
+	uID := result.InsertedID.(primitive.ObjectID)
+	jwt := createTokenString(uID.Hex(), 30*24*time.Hour) // expires in 30 days for "activate"
+	link := clientBaseURL + "register?token=" + jwt + "&group=" + g.Name + "&groupID=" + g.ID.Hex()
+	go jdchaimailer.SendWelcomRegistration(g.Name, u.Email, link)
+	return uID, nil
+}
+
 /*CreateInvites is used by a group admin to create or update accounts
 with a valid group. It will fire off emails depending on the user's current system status.
 */
@@ -87,55 +119,30 @@ func CreateInvites(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	// find group
-	gid, err := primitive.ObjectIDFromHex(data.GroupID)
-	if err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
-		return
-	}
-	g := &Group{}
-	err = mh.GetGroup(g, bson.M{"_id": gid})
-	if err != nil {
-		render.Render(w, r, ErrNotFound(err))
-		return
-	}
-	// must be made by admin of group to create
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	uid, _ := primitive.ObjectIDFromHex(claims["userID"].(string))
-	if ok := g.HasAdmin(uid); !ok {
-		render.Render(w, r, ErrAuth(errors.New("not authorized for this group")))
-		return
-	}
-
-	// create or update users and email in mailer thread
-	rr := RegisterRequest{"", "", "", "password", gid}
+	g, _ := requestGroup(r)
 	for _, m := range data.MemberEmails {
-		// TODO: verify email
-		rr.Email = m
-		u, err := NewUser(rr)
-		if err != nil {
-			if u != nil {
-				// user exists
-				if !u.inGroup(gid) {
-					update := bson.M{"$addToSet": bson.M{"groups": gid}}
-					if err := mh.UpdateUsers(bson.M{"_id": u.ID}, update); err != nil {
-						render.Render(w, r, ErrServer(err))
-						return
-					}
-					go jdchaimailer.SendGroupInvite(g.Name, u.FirstName, u.Email)
-				}
-				continue
-			} else {
-				render.Render(w, r, ErrInvalidRequest(err))
-				return
-			}
+		if _, errResp := inviteUser(*g, m); errResp != nil {
+			render.Render(w, r, errResp)
+			return
 		}
-		// user not in system, so we create and send welcome registration
-		uid, err := mh.InsertUser(u)
-		if uID, ok := uid.InsertedID.(primitive.ObjectID); ok {
-			jwt := createTokenString(uID.Hex(), 30*24*time.Hour) // expires in 30 days for "activate"
-			link := clientBaseURL + "register?token=" + jwt + "&group=" + g.Name + "&groupID=" + g.ID.Hex()
-			go jdchaimailer.SendWelcomRegistration(g.Name, u.Email, link)
+	}
+	// members keep their membership when also invited as observers
+	observers := make([]primitive.ObjectID, 0, len(data.ObserverEmails))
+	for _, o := range data.ObserverEmails {
+		uid, errResp := inviteUser(*g, o)
+		if errResp != nil {
+			render.Render(w, r, errResp)
+			return
+		}
+		if !g.HasUser(uid) && !g.HasAdmin(uid) {
+			observers = append(observers, uid)
+		}
+	}
+	if len(observers) > 0 {
+		update := bson.M{"$addToSet": bson.M{"observers": bson.M{"$each": observers}}}
+		if err := mh.UpdateGroup(bson.M{"_id": g.ID}, update); err != nil {
+			render.Render(w, r, ErrServer(err))
+			return
 		}
 	}
 	render.Status(r, http.StatusCreated)
@@ -150,8 +157,11 @@ func AcceptRegisterInvite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// must be made by admin of group to create
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	uid, _ := primitive.ObjectIDFromHex(claims["userID"].(string))
+	uid := requestUserID(r)
+	if uid.IsZero() {
+		render.Render(w, r, ErrAuth(errors.New("token does not name a user")))
+		return
+	}
 
 	pwd, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"log"
 	"net/smtp"
+	"strings"
 )
 
 var from string
@@ -29,11 +30,16 @@ func NewEmailRequest(to []string, from, subject, body string) *EmailRequest {
 	return &EmailRequest{
 		to:      to,
 		from:    from,
-		subject: subject,
+		subject: headerValue(subject),
 		body:    body,
 	}
 }
 
+// headerValue strips line breaks so user text in a header cannot start new headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
 //SendEmail smtp
 func (r *EmailRequest) SendEmail() (bool, error) {
 	if smtpAuth == nil {
@@ -103,3 +109,131 @@ func SendGroupInvite(group, name, email string) {
 		log.Println("group invite email template parse failed")
 	}
 }
+
+// SendBlackoutNotice tells an owner which of their units were removed by a blackout
+func SendBlackoutNotice(group, email string, starts []string, reason string) {
+	templateData := struct {
+		Group  string
+		Starts []string
+		Reason string
+	}{
+		Group:  group,
+		Starts: starts,
+		Reason: reason,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, "Schedule change in JDScheduler Group: "+group, "")
+	if err := r.ParseTemplate("mailer/blackout.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("blackout email template parse failed")
+	}
+}
+
+// SendConditionReport tells the owner of the next unit that issues were reported at the last check-out
+func SendConditionReport(group, email, start, notes string) {
+	templateData := struct {
+		Group string
+		Start string
+		Notes string
+	}{
+		Group: group,
+		Start: start,
+		Notes: notes,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, "Condition report in JDScheduler Group: "+group, "")
+	if err := r.ParseTemplate("mailer/condition.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("condition report email template parse failed")
+	}
+}
+
+// SendTaskReminder reminds a task's assignee that it is coming due
+func SendTaskReminder(group, email, task, due string) {
+	templateData := struct {
+		Group string
+		Task  string
+		Due   string
+	}{
+		Group: group,
+		Task:  task,
+		Due:   due,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, "Task due in JDScheduler Group: "+group, "")
+	if err := r.ParseTemplate("mailer/taskreminder.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("task reminder email template parse failed")
+	}
+}
+
+// SendUnassignedTask tells a group admin that a task coming due has no owner to remind
+func SendUnassignedTask(group, email, task, due string) {
+	templateData := struct {
+		Group string
+		Task  string
+		Due   string
+	}{
+		Group: group,
+		Task:  task,
+		Due:   due,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, "Unassigned task due in JDScheduler Group: "+group, "")
+	if err := r.ParseTemplate("mailer/taskunassigned.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("unassigned task email template parse failed")
+	}
+}
+
+// SendAnnouncement emails a group announcement to a member
+func SendAnnouncement(group, email, title, body string) {
+	templateData := struct {
+		Group string
+		Title string
+		Body  string
+	}{
+		Group: group,
+		Title: title,
+		Body:  body,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, group+": "+title, "")
+	if err := r.ParseTemplate("mailer/announcement.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("announcement email template parse failed")
+	}
+}
+
+// SendEmailVerification asks a user to verify the new email address of their account
+func SendEmailVerification(email, url string) {
+	templateData := struct {
+		URL string
+	}{
+		URL: url,
+	}
+	address := []string{email}
+	r := NewEmailRequest(address, from, "Verify your JDScheduler email", "")
+	if err := r.ParseTemplate("mailer/verifyemail.html", templateData); err == nil {
+		if _, err := r.SendEmail(); err != nil {
+			log.Println("smtp error: " + err.Error())
+		}
+	} else {
+		log.Println("email verification template parse failed")
+	}
+}
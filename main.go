@@ -9,7 +9,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
+	jdchaiblob "github.com/ede0m/jdchai/blob"
 	jdchaimailer "github.com/ede0m/jdchai/mailer"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -22,10 +24,12 @@ import (
 // mongo client
 var mh = NewMongoHandler()
 var tokenAuth *jwtauth.JWTAuth
+var emailTokenAuth *jwtauth.JWTAuth
 var smtpAuth smtp.Auth
 var host string
 var port string
 var clientBaseURL string
+var blobStore jdchaiblob.Store
 
 func main() {
 
@@ -42,12 +46,29 @@ func main() {
 
 	// jwt setup
 	tokenAuth = jwtauth.New("HS256", []byte(configuration.JWTSecret), nil)
+	// email verification tokens have their own key so they never pass as login tokens
+	emailTokenAuth = jwtauth.New("HS256", []byte("verify-email:"+configuration.JWTSecret), nil)
 
 	// mailer setup
 	smtpAuth = smtp.PlainAuth("", configuration.APIMailerAddress, configuration.APIMailerPassword, "smtp.gmail.com")
 	jdchaimailer.Init(smtpAuth, configuration.APIMailerAddress)
 
+	// blob store setup
+	blobRoot := configuration.BlobRoot
+	if blobRoot == "" {
+		blobRoot = "uploads"
+	}
+	if blobStore, err = jdchaiblob.NewLocalStore(blobRoot); err != nil {
+		panic(err)
+	}
+
 	defer mh.client.Disconnect(context.Background())
+	go RunTaskReminders(time.Hour)
+	http.ListenAndServe(host+":"+port, newRouter())
+}
+
+// newRouter routes the api, checking tokens and group permissions
+func newRouter() http.Handler {
 	r := chi.NewRouter()
 
 	// Basic CORS
@@ -66,6 +87,10 @@ func main() {
 	r.Use(middleware.Logger)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
+	// group permissions
+	observe, view, use := Authorize(ObserveGroup), Authorize(ViewGroup), Authorize(UseGroup)
+	schedule, manage, own := Authorize(ManageSchedule), Authorize(ManageGroup), Authorize(OwnGroup)
+
 	r.Route("/schedule", func(r chi.Router) {
 		r.Post("/", GenerateSchedule)
 		// Protected routes
@@ -75,8 +100,25 @@ func main() {
 			// Handle valid / invalid tokens.
 			r.Use(jwtauth.Authenticator)
 
-			r.Post("/master", CreateMasterSchedule)
-			r.Get("/master/{groupID}", GetMasterSchedule)
+			r.With(schedule).Post("/master", CreateMasterSchedule)
+			r.With(schedule).Post("/master/regenerate", RegenerateMasterSchedule)
+			r.With(observe).Get("/master/{groupID}", GetMasterSchedule)
+			r.With(observe).Get("/master/{groupID}/export", ExportMasterSchedule)
+			r.With(schedule).Post("/master/{groupID}/import", ImportMasterSchedule)
+			r.With(schedule).Post("/master/{groupID}/extend", ExtendMasterSchedule)
+			r.With(view).Get("/master/{groupID}/version", GetMasterScheduleVersions)
+			r.With(view).Get("/master/{groupID}/version/{scheduleID}", GetMasterScheduleVersion)
+			r.With(view).Get("/master/{groupID}/diff", DiffMasterSchedules)
+			r.With(view).Post("/master/{groupID}/report", GetFairnessReport)
+			r.With(use).Post("/master/{groupID}/unit/{unitID}/split", SplitScheduleUnit)
+			r.With(use).Post("/master/{groupID}/unit/{unitID}/merge", MergeScheduleUnit)
+			r.With(use).Post("/master/{groupID}/unit/{unitID}/booking", CreateGuestBooking)
+			r.With(use).Delete("/master/{groupID}/unit/{unitID}/booking/{bookingID}", DeleteGuestBooking)
+			r.With(view).Get("/master/{groupID}/unit/{unitID}/stay", GetStay)
+			r.With(observe).Post("/master/{groupID}/unit/{unitID}/stay/checkin", CheckIn)
+			r.With(observe).Post("/master/{groupID}/unit/{unitID}/stay/checkout", CheckOut)
+			r.With(observe).Post("/master/{groupID}/unit/{unitID}/stay/photo", UploadStayPhoto)
+			r.With(view).Get("/master/{groupID}/unit/{unitID}/stay/photo", GetStayPhoto)
 		})
 	})
 
@@ -85,11 +127,42 @@ func main() {
 		r.Use(jwtauth.Authenticator)
 		r.Route("/group", func(r chi.Router) {
 			r.Post("/", CreateGroup)
-			r.Post("/invitation", CreateInvites)
-			r.Get("/{groupID}/user", GetGroupUsers)
+			r.With(manage).Post("/invitation", CreateInvites)
+			r.With(manage).Get("/{groupID}", GetGroupDetails)
+			r.With(manage).Patch("/{groupID}", UpdateGroupSettings)
+			r.With(own).Delete("/{groupID}", DeleteGroup)
+			r.Post("/{groupID}/restore", RestoreGroup)
+			r.With(view).Get("/{groupID}/user", GetGroupUsers)
+			r.With(manage).Delete("/{groupID}/user/{userID}", RemoveGroupUser)
+			r.With(manage).Put("/{groupID}/user/{userID}/role", SetMemberRole)
+			r.With(observe).Post("/{groupID}/leave", LeaveGroup)
+			r.With(manage).Put("/{groupID}/admin/{userID}", PromoteAdmin)
+			r.With(manage).Delete("/{groupID}/admin/{userID}", DemoteAdmin)
+			r.With(own).Put("/{groupID}/owner", TransferOwnership)
+			r.With(view).Get("/{groupID}/blackout", GetBlackouts)
+			r.With(schedule).Post("/{groupID}/blackout", CreateBlackout)
+			r.With(schedule).Delete("/{groupID}/blackout/{blackoutID}", DeleteBlackout)
+			r.With(view).Get("/{groupID}/resource", GetResources)
+			r.With(manage).Post("/{groupID}/resource", CreateResource)
+			r.With(view).Get("/{groupID}/expense", GetExpenses)
+			r.With(use).Post("/{groupID}/expense", CreateExpense)
+			r.With(view).Get("/{groupID}/balance", GetBalances)
+			r.With(use).Post("/{groupID}/settlement", SettleUp)
+			r.With(view).Get("/{groupID}/task", GetTasks)
+			r.With(manage).Post("/{groupID}/task", CreateTask)
+			r.With(use).Patch("/{groupID}/task/{taskID}", CompleteTask)
+			r.With(observe).Get("/{groupID}/post", GetPosts)
+			r.With(use).Post("/{groupID}/post", CreatePost)
+			r.With(use).Post("/{groupID}/post/{postID}/reply", ReplyToPost)
+			r.With(manage).Patch("/{groupID}/post/{postID}/pin", PinPost)
+			r.With(use).Delete("/{groupID}/post/{postID}", DeletePost)
 		})
 		r.Route("/user", func(r chi.Router) {
 			r.Patch("/invitation", AcceptRegisterInvite)
+			r.Get("/me", GetProfile)
+			r.Patch("/me", UpdateProfile)
+			r.Patch("/me/email", VerifyEmail)
+			r.Get("/me/groups", GetUserGroups)
 			r.Get("/{userID}/trade", GetUserTrades)
 		})
 		r.Route("/trade", func(r chi.Router) {
@@ -102,7 +175,7 @@ func main() {
 		r.Post("/", LoginUser)
 	})
 
-	http.ListenAndServe(host+":"+port, r)
+	return r
 }
 
 // AllowOriginFunc logic for cors
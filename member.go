@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Handover plans for the units of a member leaving a group
+const (
+	ReassignHandover   = "reassign"   // every unit goes to one named member
+	ReleaseHandover    = "release"    // units go back to the group pool without an owner
+	RoundRobinHandover = "roundrobin" // units are dealt out to the remaining members in date order
+)
+
+// HandoverRequest says what happens to the units of a member leaving a group
+type HandoverRequest struct {
+	Plan     string `json:"plan"`
+	ToUserID string `json:"toUserId"` // for the reassign plan
+}
+
+// HandoverResponse lists the units handed over in each of the group's schedules, unit id to new owner
+type HandoverResponse struct {
+	Units map[string]map[string]string `json:"units"`
+}
+
+// Bind binds the http req to handoverRequest type as the render
+func (hr *HandoverRequest) Bind(r *http.Request) error {
+	switch hr.Plan {
+	case ReassignHandover:
+		if hr.ToUserID == "" {
+			return errors.New("reassign handover must name the member to reassign units to")
+		}
+	case ReleaseHandover, RoundRobinHandover:
+	default:
+		return errors.New("handover plan should be reassign, release or roundrobin")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (hr *HandoverResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// groupSchedules returns the current master schedule of every resource of a group that has one
+func groupSchedules(g Group) ([]*MasterSchedule, error) {
+	rids := []primitive.ObjectID{primitive.NilObjectID}
+	for _, res := range g.Resources {
+		rids = append(rids, res.ID)
+	}
+	var schs []*MasterSchedule
+	for _, rid := range rids {
+		ms := &MasterSchedule{}
+		if err := mh.GetMasterSchedule(ms, masterScheduleFilter(g.ID, rid)); err == mongo.ErrNoDocuments {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		schs = append(schs, ms)
+	}
+	return schs, nil
+}
+
+/*Handover gives away every unit a leaving member owns following a handover plan. remaining are the
+emails of the members staying, used by the round robin plan. It returns the new owner of each unit */
+func (ms *MasterSchedule) Handover(email, plan, to string, remaining []string) (map[string]string, error) {
+	var ids []string
+	for id, smu := range ms.ScheduleUnitMap {
+		if smu.Owner == email {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ms.ScheduleUnitMap[ids[i]].Start.Before(ms.ScheduleUnitMap[ids[j]].Start) })
+	if plan == RoundRobinHandover && len(ids) > 0 && len(remaining) == 0 {
+		return nil, errors.New("no remaining members to hand units over to")
+	}
+
+	handedOver := make(map[string]string)
+	for i, id := range ids {
+		owner := ""
+		switch plan {
+		case ReassignHandover:
+			owner = to
+		case RoundRobinHandover:
+			owner = remaining[i%len(remaining)]
+		}
+		ms.setOwner(id, owner)
+		handedOver[id] = owner
+	}
+
+	// the leaving member no longer takes part in the rotation
+	participants := make([]string, 0, len(ms.Schedule.Participants))
+	for _, p := range ms.Schedule.Participants {
+		if p != email {
+			participants = append(participants, p)
+		}
+	}
+	if plan == ReassignHandover && indexOf(to, participants) < 0 {
+		participants = append(participants, to)
+	}
+	ms.Schedule.Participants = participants
+	return handedOver, nil
+}
+
+// removeMember hands over a member's units and takes them out of a group
+func removeMember(w http.ResponseWriter, r *http.Request, g *Group, uid primitive.ObjectID, data *HandoverRequest) {
+	if !g.HasUser(uid) && !g.HasAdmin(uid) && !g.HasObserver(uid) {
+		render.Render(w, r, ErrNotFound(errors.New("user not in group")))
+		return
+	}
+	if g.Owner() == uid {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the group owner must transfer ownership before leaving")))
+		return
+	}
+	if g.HasAdmin(uid) && len(g.Admins) == 1 {
+		render.Render(w, r, ErrInvalidRequest(errors.New("the last admin of a group cannot leave it")))
+		return
+	}
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"_id": uid}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	// observers own no units to hand over
+	if g.HasObserver(uid) {
+		if err := mh.RemoveGroupMember(g.ID, u, nil, nil); err != nil {
+			render.Render(w, r, ErrServer(err))
+			return
+		}
+		render.Status(r, http.StatusOK)
+		render.Render(w, r, &HandoverResponse{make(map[string]map[string]string)})
+		return
+	}
+
+	var remaining []string
+	to := ""
+	others := make([]primitive.ObjectID, 0, len(g.Members))
+	for _, m := range g.Members {
+		if m != uid {
+			others = append(others, m)
+		}
+	}
+	members, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": others}})
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	for _, m := range members {
+		remaining = append(remaining, m.Email)
+		if m.ID.Hex() == data.ToUserID {
+			to = m.Email
+		}
+	}
+	sort.Strings(remaining)
+	if data.Plan == ReassignHandover && to == "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("units must be reassigned to a remaining group member")))
+		return
+	}
+
+	resp := &HandoverResponse{make(map[string]map[string]string)}
+	schs, err := groupSchedules(*g)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	before := make(map[primitive.ObjectID]unitsSnapshot)
+	for _, ms := range schs {
+		before[ms.ID] = ms.snapshot()
+		units, err := ms.Handover(u.Email, data.Plan, to, remaining)
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		resp.Units[ms.ID.Hex()] = units
+	}
+	if err = mh.RemoveGroupMember(g.ID, u, schs, before); err == errScheduleChanged {
+		render.Render(w, r, ErrConflict(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
+
+////////////  CONTROLLERS //////////////////
+
+// RemoveGroupUser is used by a group admin to remove a member, handing over their units
+func RemoveGroupUser(w http.ResponseWriter, r *http.Request) {
+	data := &HandoverRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	uid, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	removeMember(w, r, g, uid, data)
+}
+
+// LeaveGroup is used by a member to leave a group, handing over their units
+func LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	data := &HandoverRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, uid := requestGroup(r)
+	removeMember(w, r, g, uid, data)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
+	"regexp"
+	"strconv"
 	"time"
 
+	jdscheduler "github.com/ede0m/jdgoscheduler"
 	"github.com/google/uuid"
 	"github.com/tkanos/gonfig"
 	"go.mongodb.org/mongo-driver/bson"
@@ -105,10 +108,72 @@ func (mh *MongoHandler) GetMasterSchedule(ms *MasterSchedule, filter interface{}
 	opts.SetSort(bson.M{"createdAt": -1})
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err := collection.FindOne(ctx, filter, opts).Decode(ms)
+	err := collection.FindOne(ctx, notArchived(filter), opts).Decode(ms)
 	return err
 }
 
+// notArchived narrows a filter to schedules of groups that are not deleted
+func notArchived(filter interface{}) bson.M {
+	return bson.M{"$and": bson.A{filter, bson.M{"archivedAt": bson.M{"$exists": false}}}}
+}
+
+// notDeleted narrows a filter to groups that are not deleted
+func notDeleted(filter interface{}) bson.M {
+	return bson.M{"$and": bson.A{filter, bson.M{"deletedAt": bson.M{"$exists": false}}}}
+}
+
+// GetMasterSchedules returns every schedule doc matching filter, newest first
+func (mh *MongoHandler) GetMasterSchedules(filter interface{}) ([]*MasterSchedule, error) {
+	collection := mh.client.Database(mh.database).Collection("schedule")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	cur, err := collection.Find(ctx, notArchived(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*MasterSchedule
+	for cur.Next(ctx) {
+		ms := &MasterSchedule{}
+		if err := cur.Decode(ms); err != nil {
+			return nil, err
+		}
+		result = append(result, ms)
+	}
+	return result, cur.Err()
+}
+
+/*AppendScheduleSeasons pushes new seasons and their pick orders onto a schedule and adds their units to the schedule unit map.
+The new units are indexed after the had seasons the schedule was read with, so nothing is written and it reports false
+when the schedule's seasons have changed since */
+func (mh *MongoHandler) AppendScheduleSeasons(schID primitive.ObjectID, had int, seasons []*jdscheduler.Season, orders []InferredPickOrder, units map[string]ScheduleMapUnit) (bool, error) {
+	collection := mh.client.Database(mh.database).Collection("schedule")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set := bson.M{}
+	for id, smu := range units {
+		set["scheduleUnitMap."+id] = smu
+	}
+	update := bson.M{
+		"$push": bson.M{
+			"schedule.seasons": bson.M{"$each": seasons},
+			"pickOrders":       bson.M{"$each": orders},
+		},
+		"$inc": bson.M{"schedule.years": len(seasons)},
+		"$set": set,
+	}
+	filter := bson.M{"_id": schID, "schedule.seasons": bson.M{"$size": had}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
 // InsertUser inserts one master schedule into scheudle colletion
 func (mh *MongoHandler) InsertUser(u *User) (*mongo.InsertOneResult, error) {
 	collection := mh.client.Database(mh.database).Collection("user")
@@ -177,13 +242,119 @@ func (mh *MongoHandler) UpdateUsers(filter interface{}, update interface{}) erro
 	return err
 }
 
+/*ChangeUserEmail sets a user's verified email and renames them from their old email in every schedule of the
+groups they belong to, or were pulled from when a group was deleted. Schedules are read in the transaction and
+only the renamed paths are written, archived versions included */
+func (mh *MongoHandler) ChangeUserEmail(uid primitive.ObjectID, from, to string) error {
+	collectionUser := mh.client.Database(mh.database).Collection("user")
+	collectionGroup := mh.client.Database(mh.database).Collection("group")
+	collectionSch := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx user error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		update := bson.M{"$set": bson.M{"email": to}, "$unset": bson.M{"pendingEmail": ""}}
+		if _, err := collectionUser.UpdateOne(sc, bson.M{"_id": uid}, update); err != nil {
+			return err
+		}
+
+		// the user's groups by membership, as deleted groups were pulled from the user
+		filter := bson.M{"$or": bson.A{
+			bson.M{"members": uid}, bson.M{"admins": uid}, bson.M{"observers": uid}, bson.M{"archivedFor": uid},
+		}}
+		cur, err := collectionGroup.Find(sc, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var groupIDs []primitive.ObjectID
+		for cur.Next(sc) {
+			g := &Group{}
+			if err := cur.Decode(g); err != nil {
+				cur.Close(sc)
+				return err
+			}
+			groupIDs = append(groupIDs, g.ID)
+		}
+		cur.Close(sc)
+		if len(groupIDs) == 0 {
+			return session.CommitTransaction(sc)
+		}
+
+		// unit owners and pick orders are keyed by unit and block, so they are set by path
+		inGroups := bson.M{"groupId": bson.M{"$in": groupIDs}}
+		projection := bson.M{"scheduleUnitMap": 1, "pickOrders": 1}
+		cur, err = collectionSch.Find(sc, inGroups, options.Find().SetProjection(projection))
+		if err != nil {
+			return err
+		}
+		var schs []*MasterSchedule
+		for cur.Next(sc) {
+			ms := &MasterSchedule{}
+			if err := cur.Decode(ms); err != nil {
+				cur.Close(sc)
+				return err
+			}
+			schs = append(schs, ms)
+		}
+		cur.Close(sc)
+		for _, ms := range schs {
+			if set := ms.renameSet(from, to); len(set) > 0 {
+				if _, err := collectionSch.UpdateOne(sc, bson.M{"_id": ms.ID}, bson.M{"$set": set}); err != nil {
+					return err
+				}
+			}
+		}
+
+		filter = bson.M{"groupId": bson.M{"$in": groupIDs}, "schedule.participants": from}
+		update = bson.M{"$set": bson.M{"schedule.participants.$[p]": to}}
+		arrayFiltersOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"p": from}},
+		})
+		if _, err := collectionSch.UpdateMany(sc, filter, update, arrayFiltersOpts); err != nil {
+			return err
+		}
+
+		filter = bson.M{"groupId": bson.M{"$in": groupIDs}, "$or": bson.A{
+			bson.M{"tradeLedger.initiatorEmail": from},
+			bson.M{"tradeLedger.executorEmail": from},
+		}}
+		update = bson.M{"$set": bson.M{
+			"tradeLedger.$[initiated].initiatorEmail": to,
+			"tradeLedger.$[executed].executorEmail":   to,
+		}}
+		arrayFiltersOpts = options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"initiated.initiatorEmail": from}, bson.M{"executed.executorEmail": from}},
+		})
+		if _, err := collectionSch.UpdateMany(sc, filter, update, arrayFiltersOpts); err != nil {
+			return err
+		}
+
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
 // GetUsers returns list of users specified by filter
 func (mh *MongoHandler) GetUsers(filter interface{}) ([]*User, error) {
 	collection := mh.client.Database(mh.database).Collection("user")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	projection := bson.M{"email": 1, "_id": 1, "firstName": 1, "lastName": 1} // set field to 1 to project
+	projection := bson.M{"email": 1, "_id": 1, "firstName": 1, "lastName": 1, "mutedNotifications": 1} // set field to 1 to project
 	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(projection))
 	if err != nil {
 		return nil, err
@@ -207,10 +378,195 @@ func (mh *MongoHandler) GetGroup(g *Group, filter interface{}) error {
 	collection := mh.client.Database(mh.database).Collection("group")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err := collection.FindOne(ctx, filter).Decode(g)
+	err := collection.FindOne(ctx, notDeleted(filter)).Decode(g)
 	return err
 }
 
+// GetDeletedGroup get a deleted group doc by filter
+func (mh *MongoHandler) GetDeletedGroup(g *Group, filter interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("group")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter = bson.M{"$and": bson.A{filter, bson.M{"deletedAt": bson.M{"$exists": true}}}}
+	return collection.FindOne(ctx, filter).Decode(g)
+}
+
+/*ArchiveGroup soft deletes a group. Its schedules (and their trades) are archived and it is pulled
+from its users' groups, in one transaction */
+func (mh *MongoHandler) ArchiveGroup(groupID primitive.ObjectID, at time.Time) error {
+	collectionGroup := mh.client.Database(mh.database).Collection("group")
+	collectionUser := mh.client.Database(mh.database).Collection("user")
+	collectionSch := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx group error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		// remember who had the group to restore it for them
+		cur, err := collectionUser.Find(sc, bson.M{"groups": groupID}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		users := make([]primitive.ObjectID, 0)
+		for cur.Next(sc) {
+			u := &User{}
+			if err := cur.Decode(u); err != nil {
+				return err
+			}
+			users = append(users, u.ID)
+		}
+		cur.Close(sc)
+
+		update := bson.M{"$set": bson.M{"deletedAt": at, "archivedFor": users}}
+		if _, err := collectionGroup.UpdateOne(sc, bson.M{"_id": groupID}, update); err != nil {
+			return err
+		}
+		if _, err := collectionSch.UpdateMany(sc, bson.M{"groupId": groupID}, bson.M{"$set": bson.M{"archivedAt": at}}); err != nil {
+			return err
+		}
+		if _, err := collectionUser.UpdateMany(sc, bson.M{"_id": bson.M{"$in": users}}, bson.M{"$pull": bson.M{"groups": groupID}}); err != nil {
+			return err
+		}
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
+// RestoreGroup undoes ArchiveGroup in one transaction
+func (mh *MongoHandler) RestoreGroup(g *Group) error {
+	collectionGroup := mh.client.Database(mh.database).Collection("group")
+	collectionUser := mh.client.Database(mh.database).Collection("user")
+	collectionSch := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx group error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		update := bson.M{"$unset": bson.M{"deletedAt": "", "archivedFor": ""}}
+		if _, err := collectionGroup.UpdateOne(sc, bson.M{"_id": g.ID}, update); err != nil {
+			return err
+		}
+		update = bson.M{"$unset": bson.M{"archivedAt": ""}}
+		if _, err := collectionSch.UpdateMany(sc, bson.M{"groupId": g.ID}, update); err != nil {
+			return err
+		}
+		update = bson.M{"$addToSet": bson.M{"groups": g.ID}}
+		if _, err := collectionUser.UpdateMany(sc, bson.M{"_id": bson.M{"$in": g.ArchivedFor}}, update); err != nil {
+			return err
+		}
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
+/*RemoveGroupMember takes a user out of a group's members, admins and roles and the group out of the user's groups.
+The units handed over in each schedule since its before snapshot are stored, and the user's open trades voided, in one transaction */
+func (mh *MongoHandler) RemoveGroupMember(groupID primitive.ObjectID, u *User, schs []*MasterSchedule, before map[primitive.ObjectID]unitsSnapshot) error {
+	collectionGroup := mh.client.Database(mh.database).Collection("group")
+	collectionUser := mh.client.Database(mh.database).Collection("user")
+	collectionSch := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx group error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		update := bson.M{
+			"$pull":  bson.M{"members": u.ID, "admins": u.ID, "observers": u.ID},
+			"$unset": bson.M{"roles." + u.ID.Hex(): ""},
+		}
+		if _, err := collectionGroup.UpdateOne(sc, bson.M{"_id": groupID}, update); err != nil {
+			return err
+		}
+		if _, err := collectionUser.UpdateOne(sc, bson.M{"_id": u.ID}, bson.M{"$pull": bson.M{"groups": groupID}}); err != nil {
+			return err
+		}
+		for _, sch := range schs {
+			if err := storeScheduleUnits(sc, collectionSch, sch, before[sch.ID], nil); err != nil {
+				return err
+			}
+			update = bson.M{"$set": bson.M{"tradeLedger.$[trade].status": Void}}
+			arrayFiltersOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+				Filters: []interface{}{bson.M{
+					"trade.status": Open,
+					"$or": bson.A{
+						bson.M{"trade.initiatorEmail": u.Email},
+						bson.M{"trade.executorEmail": u.Email},
+					},
+				}},
+			})
+			if _, err := collectionSch.UpdateOne(sc, bson.M{"_id": sch.ID}, update, arrayFiltersOpts); err != nil {
+				return err
+			}
+		}
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		// a changed schedule is expected, so end the session to abort the transaction now
+		session.EndSession(ctx)
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
+// GetGroups returns every group doc matching filter
+func (mh *MongoHandler) GetGroups(filter interface{}) ([]*Group, error) {
+	collection := mh.client.Database(mh.database).Collection("group")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, notDeleted(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*Group
+	for cur.Next(ctx) {
+		g := &Group{}
+		if err := cur.Decode(g); err != nil {
+			return nil, err
+		}
+		result = append(result, g)
+	}
+	return result, cur.Err()
+}
+
 // InsertGroup create new users if needed, creates a group with all members, adds groups to each member,
 //  then creates the group schedule in transaction
 func (mh *MongoHandler) InsertGroup(g *Group, sch *MasterSchedule, newUsers []*User, existingUsers []*User) (primitive.ObjectID, error) {
@@ -294,6 +650,414 @@ func (mh *MongoHandler) InsertGroup(g *Group, sch *MasterSchedule, newUsers []*U
 	return groupID, nil
 }
 
+// UpdateGroup updates one group with filter and update
+func (mh *MongoHandler) UpdateGroup(filter interface{}, update interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("group")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// UpdateGroupIf updates one group with filter and update, reporting whether the filter matched
+func (mh *MongoHandler) UpdateGroupIf(filter interface{}, update interface{}) (bool, error) {
+	collection := mh.client.Database(mh.database).Collection("group")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// AddGroupBlackout adds a blackout to a group and, in the same transaction, removes its units from the group's schedule
+func (mh *MongoHandler) AddGroupBlackout(groupID primitive.ObjectID, b Blackout, sch *MasterSchedule, before unitsSnapshot, voidUnits []uuid.UUID) error {
+	collectionGroup := mh.client.Database(mh.database).Collection("group")
+	collectionSch := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx group error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		update := bson.M{"$push": bson.M{"blackouts": b}}
+		if _, err := collectionGroup.UpdateOne(sc, bson.M{"_id": groupID}, update); err != nil {
+			return err
+		}
+		if sch != nil {
+			if err := storeScheduleUnits(sc, collectionSch, sch, before, voidUnits); err != nil {
+				return err
+			}
+		}
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		// a changed schedule is expected, so end the session to abort the transaction now
+		session.EndSession(ctx)
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
+// UpdateMasterSchedule updates one schedule doc with filter and update
+func (mh *MongoHandler) UpdateMasterSchedule(filter interface{}, update interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("schedule")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// UpdateMasterScheduleIf updates a master schedule, reporting whether any schedule matched the filter
+func (mh *MongoHandler) UpdateMasterScheduleIf(filter interface{}, update interface{}) (bool, error) {
+	collection := mh.client.Database(mh.database).Collection("schedule")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+/*UpdateScheduleUnits stores the units of a schedule changed since the before snapshot, and voids the open trades
+of any unit in voidUnits. errScheduleChanged is returned if the changed units were changed meanwhile */
+func (mh *MongoHandler) UpdateScheduleUnits(sch *MasterSchedule, before unitsSnapshot, voidUnits []uuid.UUID) error {
+	collection := mh.client.Database(mh.database).Collection("schedule")
+
+	var session mongo.Session
+	var err error
+	if session, err = mh.client.StartSession(); err != nil {
+		return errors.New("session error")
+	}
+	if err := session.StartTransaction(); err != nil {
+		return errors.New("tx group error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		if err := storeScheduleUnits(sc, collection, sch, before, voidUnits); err != nil {
+			return err
+		}
+		if err = session.CommitTransaction(sc); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		// a changed schedule is expected, so end the session to abort the transaction now
+		session.EndSession(ctx)
+		return err
+	}
+	session.EndSession(ctx)
+	return nil
+}
+
+// storeScheduleUnits stores the units of a schedule changed since before, and voids the open trades in the group of any unit in voidUnits
+func storeScheduleUnits(sc mongo.SessionContext, collection *mongo.Collection, sch *MasterSchedule, before unitsSnapshot, voidUnits []uuid.UUID) error {
+	if filter, update := scheduleUnitsUpdate(sch, before); len(update) > 0 {
+		result, err := collection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errScheduleChanged
+		}
+	}
+	return voidUnitTrades(sc, collection, sch.GroupID, voidUnits)
+}
+
+/*scheduleUnitsUpdate builds an update of only the units of a schedule changed since before. Blocks whose units
+were added or removed are replaced as long as they are unchanged in the store, other units have just their owner
+and indicies set. Units that are removed must not have been booked meanwhile */
+func scheduleUnitsUpdate(sch *MasterSchedule, before unitsSnapshot) (bson.M, bson.M) {
+	filter := bson.M{"_id": sch.ID}
+	set, unset, pull := bson.M{}, bson.M{}, bson.M{}
+
+	if !equalStrings(sch.Schedule.Participants, before.participants) {
+		set["schedule.participants"] = sch.Schedule.Participants
+	}
+	for i, season := range sch.Schedule.Seasons {
+		for j, b := range season.Blocks {
+			path := "schedule.seasons." + strconv.Itoa(i) + ".blocks." + strconv.Itoa(j) + ".units"
+			old := before.blocks[[2]int{i, j}]
+			if !sameUnitIDs(old, b.Units) {
+				filter[path] = old
+				set[path] = b.Units
+				continue
+			}
+			for k, u := range b.Units {
+				if u.Participant != old[k].Participant {
+					unit := path + "." + strconv.Itoa(k)
+					filter[unit+".id"] = u.ID
+					set[unit+".participant"] = u.Participant
+				}
+			}
+		}
+	}
+	for id, smu := range sch.ScheduleUnitMap {
+		path := "scheduleUnitMap." + id
+		old, ok := before.units[id]
+		if !ok {
+			set[path] = smu
+			continue
+		}
+		if smu.Owner != old.Owner {
+			filter[path+".owner"] = old.Owner
+			set[path+".owner"] = smu.Owner
+			pull[path+".bookings"] = bson.M{"keepOnTrade": bson.M{"$ne": true}}
+		}
+		if !equalInts(smu.MapIndicies, old.MapIndicies) {
+			set[path+".mapIndicies"] = smu.MapIndicies
+		}
+	}
+	for id, old := range before.units {
+		if _, ok := sch.ScheduleUnitMap[id]; !ok {
+			path := "scheduleUnitMap." + id
+			unset[path] = ""
+			if len(old.Bookings) > 0 {
+				filter[path+".bookings"] = bson.M{"$size": len(old.Bookings)}
+			} else {
+				filter[path+".bookings"] = bson.M{"$exists": false}
+			}
+		}
+	}
+
+	update := bson.M{}
+	for op, fields := range map[string]bson.M{"$set": set, "$unset": unset, "$pull": pull} {
+		if len(fields) > 0 {
+			update[op] = fields
+		}
+	}
+	return filter, update
+}
+
+// sameUnitIDs reports whether two blocks hold the same units in the same order
+func sameUnitIDs(a, b []jdscheduler.Unit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// post handlers //
+
+// InsertPost inserts one post into post collection
+func (mh *MongoHandler) InsertPost(p *Post) (*mongo.InsertOneResult, error) {
+	collection := mh.client.Database(mh.database).Collection("post")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return collection.InsertOne(ctx, p)
+}
+
+// GetPost get a post
+func (mh *MongoHandler) GetPost(p *Post, filter interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("post")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return collection.FindOne(ctx, filter).Decode(p)
+}
+
+// GetPosts returns every post matching filter, pinned first then newest
+func (mh *MongoHandler) GetPosts(filter interface{}) ([]*Post, error) {
+	collection := mh.client.Database(mh.database).Collection("post")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "pinned", Value: -1}, {Key: "createdAt", Value: -1}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*Post
+	for cur.Next(ctx) {
+		p := &Post{}
+		if err := cur.Decode(p); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, cur.Err()
+}
+
+// UpdatePost updates one post with filter and update
+func (mh *MongoHandler) UpdatePost(filter interface{}, update interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("post")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// DeletePost deletes one post
+func (mh *MongoHandler) DeletePost(filter interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("post")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := collection.DeleteOne(ctx, filter)
+	return err
+}
+
+// expense handlers //
+
+// InsertExpense inserts one expense into expense collection
+func (mh *MongoHandler) InsertExpense(e *Expense) (*mongo.InsertOneResult, error) {
+	collection := mh.client.Database(mh.database).Collection("expense")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return collection.InsertOne(ctx, e)
+}
+
+// GetExpenses returns every expense matching filter, oldest first
+func (mh *MongoHandler) GetExpenses(filter interface{}) ([]*Expense, error) {
+	collection := mh.client.Database(mh.database).Collection("expense")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*Expense
+	for cur.Next(ctx) {
+		e := &Expense{}
+		if err := cur.Decode(e); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, cur.Err()
+}
+
+// InsertSettlement inserts one settlement into settlement collection
+func (mh *MongoHandler) InsertSettlement(s *Settlement) (*mongo.InsertOneResult, error) {
+	collection := mh.client.Database(mh.database).Collection("settlement")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return collection.InsertOne(ctx, s)
+}
+
+// GetSettlements returns every settlement matching filter, oldest first
+func (mh *MongoHandler) GetSettlements(filter interface{}) ([]*Settlement, error) {
+	collection := mh.client.Database(mh.database).Collection("settlement")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*Settlement
+	for cur.Next(ctx) {
+		s := &Settlement{}
+		if err := cur.Decode(s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, cur.Err()
+}
+
+// task handlers //
+
+// InsertTask inserts one task into task collection
+func (mh *MongoHandler) InsertTask(t *Task) (*mongo.InsertOneResult, error) {
+	collection := mh.client.Database(mh.database).Collection("task")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return collection.InsertOne(ctx, t)
+}
+
+// GetTask get a task
+func (mh *MongoHandler) GetTask(t *Task, filter interface{}) error {
+	collection := mh.client.Database(mh.database).Collection("task")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return collection.FindOne(ctx, filter).Decode(t)
+}
+
+// GetTasks returns every task matching filter, earliest due first
+func (mh *MongoHandler) GetTasks(filter interface{}) ([]*Task, error) {
+	collection := mh.client.Database(mh.database).Collection("task")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "due", Value: 1}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var result []*Task
+	for cur.Next(ctx) {
+		t := &Task{}
+		if err := cur.Decode(t); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, cur.Err()
+}
+
+// UpdateTask updates one task with filter and update, reporting whether the filter matched
+func (mh *MongoHandler) UpdateTask(filter interface{}, update interface{}) (bool, error) {
+	collection := mh.client.Database(mh.database).Collection("task")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
 // InsertTrade inserts one master schedule into ledger colletion
 func (mh *MongoHandler) InsertTrade(t *Trade, schID primitive.ObjectID) error {
 	collection := mh.client.Database(mh.database).Collection("schedule")
@@ -306,6 +1070,85 @@ func (mh *MongoHandler) InsertTrade(t *Trade, schID primitive.ObjectID) error {
 	return nil
 }
 
+/*GetUserGroups returns each group of a user, with the name matching search if given, together with
+the user's next unit from now and open trades in each current schedule of the group */
+func (mh *MongoHandler) GetUserGroups(uid primitive.ObjectID, search string, now time.Time) ([]UserGroupDoc, error) {
+	collection := mh.client.Database(mh.database).Collection("user")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	groupMatch := bson.M{"group.deletedAt": bson.M{"$exists": false}}
+	if search != "" {
+		groupMatch["group.name"] = primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+	}
+	// latest schedule of each group resource, reduced to the user's next unit and open trade count
+	mine := func(field string) bson.M { return bson.M{"$eq": bson.A{field, "$$email"}} }
+	schedules := bson.A{
+		bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$groupId", "$$gid"}}, "archivedAt": bson.M{"$exists": false}}},
+		bson.M{"$sort": bson.M{"createdAt": -1}},
+		bson.M{"$group": bson.M{
+			"_id":    "$resourceId",
+			"schId":  bson.M{"$first": "$_id"},
+			"units":  bson.M{"$first": "$scheduleUnitMap"},
+			"trades": bson.M{"$first": "$tradeLedger"},
+		}},
+		bson.M{"$project": bson.M{
+			"_id":        "$schId",
+			"resourceId": "$_id",
+			"next": bson.M{"$reduce": bson.M{
+				"input": bson.M{"$filter": bson.M{
+					"input": bson.M{"$objectToArray": "$units"},
+					"as":    "unit",
+					"cond":  bson.M{"$and": bson.A{mine("$$unit.v.owner"), bson.M{"$gte": bson.A{"$$unit.v.start", now}}}},
+				}},
+				"initialValue": nil,
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$or": bson.A{bson.M{"$eq": bson.A{"$$value", nil}}, bson.M{"$lt": bson.A{"$$this.v.start", "$$value.v.start"}}}},
+					"$$this",
+					"$$value",
+				}},
+			}},
+			"pendingTrades": bson.M{"$size": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$trades", bson.A{}}},
+				"as":    "trade",
+				"cond": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$$trade.status", Open}},
+					bson.M{"$or": bson.A{mine("$$trade.initiatorEmail"), mine("$$trade.executorEmail")}},
+				}},
+			}}},
+		}},
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"_id": uid}},
+		{"$lookup": bson.M{"from": "group", "localField": "groups", "foreignField": "_id", "as": "group"}},
+		{"$unwind": "$group"},
+		{"$match": groupMatch},
+		{"$lookup": bson.M{
+			"from":     "schedule",
+			"let":      bson.M{"gid": "$group._id", "email": "$email"},
+			"pipeline": schedules,
+			"as":       "schedules",
+		}},
+		{"$project": bson.M{"group": 1, "schedules": 1}},
+		{"$sort": bson.M{"group.name": 1}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []UserGroupDoc
+	for cursor.Next(ctx) {
+		doc := UserGroupDoc{}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		result = append(result, doc)
+	}
+	return result, cursor.Err()
+}
+
 // GetActiveScheduleUserTrades returns a user's trades for all active user groups in groupIDs
 func (mh *MongoHandler) GetActiveScheduleUserTrades(groupIDs []primitive.ObjectID, email string) []GroupTrades {
 
@@ -315,16 +1158,18 @@ func (mh *MongoHandler) GetActiveScheduleUserTrades(groupIDs []primitive.ObjectI
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	match := bson.M{"$match": bson.M{"groupId": bson.M{"$in": groupIDs}}}
+	match := bson.M{"$match": bson.M{"groupId": bson.M{"$in": groupIDs}, "archivedAt": bson.M{"$exists": false}}}
 	sort := bson.M{"$sort": bson.M{"groupId": -1, "createdAt": -1}}
+	// latest schedule of each group resource
 	group := bson.M{"$group": bson.M{
-		"_id":    "$groupId",
+		"_id":    bson.M{"groupId": "$groupId", "resourceId": "$resourceId"},
 		"schId":  bson.M{"$first": "$_id"},
 		"trades": bson.M{"$first": "$tradeLedger"},
 	}}
 	project := bson.M{"$project": bson.M{
-		"_id":     "$schId",
-		"groupId": "$_id",
+		"_id":        "$schId",
+		"groupId":    "$_id.groupId",
+		"resourceId": "$_id.resourceId",
 		"trades": bson.M{"$filter": bson.M{
 			"input": "$trades",
 			"as":    "trade",
@@ -389,8 +1234,17 @@ func (mh *MongoHandler) UpdateTrade(filter interface{}, update interface{}) erro
 	return err
 }
 
-// ExecuteTrade will execute a trade, void competeing trades and reflect it in the schedule
-func (mh *MongoHandler) ExecuteTrade(t *Trade, sch *MasterSchedule) error {
+// errStaleTrade is returned when a trader no longer owns a unit of a trade
+var errStaleTrade = errors.New("a traded unit is no longer owned by its trader")
+
+// errScheduleChanged is returned when units were changed by someone else since they were read
+var errScheduleChanged = errors.New("the schedule was changed meanwhile, try again")
+
+/*ExecuteTrade will execute a trade, void competeing trades and reflect it in the schedule. schID holds the
+initiator trades and execSchID the executor trades, which is schID unless they are from another resource.
+The schedules are read in the transaction and every unit must still be owned by its trader, otherwise
+nothing is written and errStaleTrade is returned */
+func (mh *MongoHandler) ExecuteTrade(t *Trade, schID, execSchID primitive.ObjectID) error {
 	collection := mh.client.Database(mh.database).Collection("schedule")
 
 	var unitIDs []uuid.UUID
@@ -415,30 +1269,32 @@ func (mh *MongoHandler) ExecuteTrade(t *Trade, sch *MasterSchedule) error {
 
 	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
 
-		// void out ALL/ANY other trades that share any traded units (uuids)
-		filter := bson.M{"_id": sch.ID}
-		update := bson.M{"$set": bson.M{"tradeLedger.$[trade].status": 2}} // 2 is void
-
-		arrayFiltersOpts := options.Update().SetArrayFilters(options.ArrayFilters{
-			Filters: []interface{}{bson.M{
-				"$or": bson.A{
-					bson.M{"trade.initiatorTrades": bson.M{"$in": unitIDs}},
-					bson.M{"trade.executorTrades": bson.M{"$in": unitIDs}},
-				},
-			}},
-		})
-		if _, err := collection.UpdateOne(sc, filter, update, arrayFiltersOpts); err != nil {
+		// update this trade status executed, unless it was finalized meanwhile
+		filter := bson.M{"_id": schID, "tradeLedger": bson.M{"$elemMatch": bson.M{"_id": t.ID, "status": Open}}}
+		update := bson.M{"$set": bson.M{"tradeLedger.$.status": Executed}}
+		result, err := collection.UpdateOne(sc, filter, update)
+		if err != nil {
 			return err
 		}
-		// update this trade status executed
-		// reflect trade in schedule
-		filter = bson.M{"_id": sch.ID, "tradeLedger._id": t.ID}
-		update = bson.M{"$set": bson.M{
-			"tradeLedger.$.status": 1, // 1 is executed
-			"schedule":             sch.Schedule,
-			"scheduleUnitMap":      sch.ScheduleUnitMap,
-		}}
-		if _, err := collection.UpdateOne(sc, filter, update); err != nil {
+		if result.MatchedCount == 0 {
+			return errStaleTrade
+		}
+		// reflect trade in schedules while the traders still own their units
+		sch, execSch := &MasterSchedule{}, &MasterSchedule{}
+		if err := collection.FindOne(sc, notArchived(bson.M{"_id": schID})).Decode(sch); err != nil {
+			return err
+		}
+		if err := collection.FindOne(sc, notArchived(bson.M{"_id": execSchID})).Decode(execSch); err != nil {
+			return err
+		}
+		if err := tradeUnits(sc, collection, sch, t.InitiatorTrades, t.InitiatorEmail, t.ExecutorEmail); err != nil {
+			return err
+		}
+		if err := tradeUnits(sc, collection, execSch, t.ExecutorTrades, t.ExecutorEmail, t.InitiatorEmail); err != nil {
+			return err
+		}
+		// void out ALL/ANY other open trades of the group that share any traded units (uuids)
+		if err := voidUnitTrades(sc, collection, sch.GroupID, unitIDs); err != nil {
 			return err
 		}
 		if err = session.CommitTransaction(sc); err != nil {
@@ -446,8 +1302,63 @@ func (mh *MongoHandler) ExecuteTrade(t *Trade, sch *MasterSchedule) error {
 		}
 		return nil
 	}); err != nil {
+		// stale trades are expected, so end the session to abort the transaction now
+		session.EndSession(ctx)
 		return err
 	}
 	session.EndSession(ctx)
 	return nil
 }
+
+/*tradeUnits gives a trader's units in a schedule read in the transaction to the other trader, as long as
+the trader owns each of them. Bookings that do not stay with a unit are cancelled */
+func tradeUnits(sc mongo.SessionContext, collection *mongo.Collection, sch *MasterSchedule, units []TradeUnit, from, to string) error {
+	for _, tu := range units {
+		id := tu.ID.String()
+		smu, ok := sch.ScheduleUnitMap[id]
+		if !ok || smu.Owner != from {
+			return errStaleTrade
+		}
+		if len(smu.MapIndicies) != 3 {
+			return errors.New("schedule map unit indicies corrupt")
+		}
+		update := bson.M{
+			"$set":  unitOwnerSet(id, smu.MapIndicies, to),
+			"$pull": bson.M{"scheduleUnitMap." + id + ".bookings": bson.M{"keepOnTrade": bson.M{"$ne": true}}},
+		}
+		if _, err := collection.UpdateOne(sc, bson.M{"_id": sch.ID}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unitOwnerSet sets the owner of a unit in both the schedule unit map and the schedule
+func unitOwnerSet(id string, indicies []int, owner string) bson.M {
+	unit := "schedule.seasons." + strconv.Itoa(indicies[0]) + ".blocks." + strconv.Itoa(indicies[1]) + ".units." + strconv.Itoa(indicies[2])
+	return bson.M{"scheduleUnitMap." + id + ".owner": owner, unit + ".participant": owner}
+}
+
+// voidUnitTrades voids the open trades of any unit in unitIDs, in every schedule of a group
+func voidUnitTrades(sc mongo.SessionContext, collection *mongo.Collection, groupID primitive.ObjectID, unitIDs []uuid.UUID) error {
+	if len(unitIDs) == 0 {
+		return nil
+	}
+	shared := bson.A{
+		bson.M{"initiatorTrades._id": bson.M{"$in": unitIDs}},
+		bson.M{"executorTrades._id": bson.M{"$in": unitIDs}},
+	}
+	filter := bson.M{"groupId": groupID, "tradeLedger": bson.M{"$elemMatch": bson.M{"status": Open, "$or": shared}}}
+	update := bson.M{"$set": bson.M{"tradeLedger.$[trade].status": Void}}
+	arrayFiltersOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{
+			"trade.status": Open,
+			"$or": bson.A{
+				bson.M{"trade.initiatorTrades._id": bson.M{"$in": unitIDs}},
+				bson.M{"trade.executorTrades._id": bson.M{"$in": unitIDs}},
+			},
+		}},
+	})
+	_, err := collection.UpdateMany(sc, filter, update, arrayFiltersOpts)
+	return err
+}
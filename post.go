@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	jdchaimailer "github.com/ede0m/jdchai/mailer"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Post is a message on a group's board. Announcements are posts by admins
+type Post struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GroupID      primitive.ObjectID `json:"groupId" bson:"groupId"`
+	AuthorID     primitive.ObjectID `json:"authorId" bson:"authorId"`
+	Title        string             `json:"title" bson:"title"`
+	Body         string             `json:"body" bson:"body"`
+	Announcement bool               `json:"announcement" bson:"announcement"`
+	Pinned       bool               `json:"pinned" bson:"pinned"`
+	Replies      []Reply            `json:"replies" bson:"replies"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Reply is a response to a post
+type Reply struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	AuthorID  primitive.ObjectID `json:"authorId" bson:"authorId"`
+	Body      string             `json:"body" bson:"body"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// PostRequest is a request by a group member to post on the board
+type PostRequest struct {
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	Announcement bool   `json:"announcement"` // admins only
+	Pinned       bool   `json:"pinned"`       // admins only
+	Email        bool   `json:"email"`        // email an announcement to every member
+}
+
+// ReplyRequest is a request by a group member to reply to a post
+type ReplyRequest struct {
+	Body string `json:"body"`
+}
+
+// PinRequest is a request by a group admin to pin or unpin a post
+type PinRequest struct {
+	Pinned bool `json:"pinned"`
+}
+
+// PostResponse is the client response for a post
+type PostResponse struct {
+	Post Post `json:"post"`
+}
+
+// PostsResponse lists a group's posts, pinned first then newest
+type PostsResponse struct {
+	Posts []*Post `json:"posts"`
+}
+
+// Bind binds the http req to postRequest type as the render
+func (pr *PostRequest) Bind(r *http.Request) error {
+	if pr.Title == "" || pr.Body == "" {
+		return errors.New("post must have a title and body")
+	}
+	if strings.ContainsAny(pr.Title, "\r\n") {
+		return errors.New("post title must be a single line")
+	}
+	if pr.Email && !pr.Announcement {
+		return errors.New("only announcements can be emailed")
+	}
+	return nil
+}
+
+// Bind binds the http req to replyRequest type as the render
+func (rr *ReplyRequest) Bind(r *http.Request) error {
+	if rr.Body == "" {
+		return errors.New("reply must have a body")
+	}
+	return nil
+}
+
+// Bind binds the http req to pinRequest type as the render
+func (pr *PinRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (pr *PostResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (pr *PostsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// groupPost gets a post of the request's group
+func groupPost(w http.ResponseWriter, r *http.Request, g *Group) (*Post, bool) {
+	postID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "postID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return nil, false
+	}
+	p := &Post{}
+	if err = mh.GetPost(p, bson.M{"_id": postID, "groupId": g.ID}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return nil, false
+	}
+	return p, true
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetPosts lists a group's board. Observers only get its announcements
+func GetPosts(w http.ResponseWriter, r *http.Request) {
+	g, uid := requestGroup(r)
+	filter := bson.M{"groupId": g.ID}
+	// observers only see announcements
+	if role, _ := g.Role(uid); role == ObserverRole {
+		filter["announcement"] = true
+	}
+	posts, err := mh.GetPosts(filter)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if posts == nil {
+		posts = make([]*Post, 0)
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &PostsResponse{posts})
+}
+
+// CreatePost posts on a group's board. Announcements can be emailed to every member
+func CreatePost(w http.ResponseWriter, r *http.Request) {
+	data := &PostRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, uid := requestGroup(r)
+	if (data.Announcement || data.Pinned) && !g.Can(uid, ManageGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("only group admins can make announcements")))
+		return
+	}
+	p := &Post{primitive.NilObjectID, g.ID, uid, data.Title, data.Body, data.Announcement, data.Pinned, []Reply{}, time.Now()}
+	result, err := mh.InsertPost(p)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	p.ID = result.InsertedID.(primitive.ObjectID)
+
+	if data.Email {
+		members, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Members}})
+		if err != nil {
+			render.Render(w, r, ErrServer(err))
+			return
+		}
+		for _, u := range members {
+			if u.Notifies(AnnouncementNotification) {
+				go jdchaimailer.SendAnnouncement(g.Name, u.Email, p.Title, p.Body)
+			}
+		}
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &PostResponse{*p})
+}
+
+// ReplyToPost adds a reply to a post
+func ReplyToPost(w http.ResponseWriter, r *http.Request) {
+	data := &ReplyRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, uid := requestGroup(r)
+	p, ok := groupPost(w, r, g)
+	if !ok {
+		return
+	}
+	reply := Reply{primitive.NewObjectID(), uid, data.Body, time.Now()}
+	if err := mh.UpdatePost(bson.M{"_id": p.ID}, bson.M{"$push": bson.M{"replies": reply}}); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	p.Replies = append(p.Replies, reply)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &PostResponse{*p})
+}
+
+// PinPost pins or unpins a post to the top of a group's board
+func PinPost(w http.ResponseWriter, r *http.Request) {
+	data := &PinRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	p, ok := groupPost(w, r, g)
+	if !ok {
+		return
+	}
+	if err := mh.UpdatePost(bson.M{"_id": p.ID}, bson.M{"$set": bson.M{"pinned": data.Pinned}}); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	p.Pinned = data.Pinned
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &PostResponse{*p})
+}
+
+// DeletePost removes a post. Only its author or a group admin can delete it
+func DeletePost(w http.ResponseWriter, r *http.Request) {
+	g, uid := requestGroup(r)
+	p, ok := groupPost(w, r, g)
+	if !ok {
+		return
+	}
+	if p.AuthorID != uid && !g.Can(uid, ManageGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("only the author or a group admin can delete a post")))
+		return
+	}
+	if err := mh.DeletePost(bson.M{"_id": p.ID}); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.NoContent(w, r)
+}
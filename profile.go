@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	jdchaimailer "github.com/ede0m/jdchai/mailer"
+	"github.com/go-chi/jwtauth"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Notifications a user can turn off
+const (
+	AnnouncementNotification = "announcements"
+	TaskReminderNotification = "taskReminders"
+	BlackoutNotification     = "blackouts"
+	StayReportNotification   = "stayReports"
+)
+
+var notificationKinds = []string{AnnouncementNotification, TaskReminderNotification, BlackoutNotification, StayReportNotification}
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// ProfileRequest is a request by a user to edit their profile. Omitted fields are unchanged
+type ProfileRequest struct {
+	FirstName     *string         `json:"firstName"`
+	LastName      *string         `json:"lastName"`
+	Phone         *string         `json:"phone"`
+	Locale        *string         `json:"locale"`
+	TimeZone      *string         `json:"timeZone"`
+	Email         *string         `json:"email"`         // changes once the new address is verified
+	Notifications map[string]bool `json:"notifications"` // notification kind to whether it is sent
+}
+
+// VerifyEmailRequest is a request by a user to confirm their new email with the token sent to it
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ProfileResponse is the client response of a user's own profile
+type ProfileResponse struct {
+	ID            string          `json:"id"`
+	Email         string          `json:"email"`
+	PendingEmail  string          `json:"pendingEmail,omitempty"`
+	FirstName     string          `json:"firstName"`
+	LastName      string          `json:"lastName"`
+	Phone         string          `json:"phone"`
+	Locale        string          `json:"locale"`
+	TimeZone      string          `json:"timeZone"`
+	Notifications map[string]bool `json:"notifications"`
+}
+
+// Bind binds the http req to profileRequest type as the render
+func (pr *ProfileRequest) Bind(r *http.Request) error {
+	if (pr.FirstName != nil && *pr.FirstName == "") || (pr.LastName != nil && *pr.LastName == "") {
+		return errors.New("enter a first name and last name")
+	}
+	if pr.Locale != nil && *pr.Locale != "" && !localePattern.MatchString(*pr.Locale) {
+		return errors.New("locale should be a language tag like en or en-US")
+	}
+	if pr.TimeZone != nil && *pr.TimeZone != "" {
+		if _, err := time.LoadLocation(*pr.TimeZone); err != nil {
+			return errors.New("unknown time zone: " + *pr.TimeZone)
+		}
+	}
+	if pr.Email != nil {
+		// TODO: check email is valid?
+		*pr.Email = strings.ToLower(strings.TrimSpace(*pr.Email))
+		if !strings.Contains(*pr.Email, "@") {
+			return errors.New("improper email")
+		}
+	}
+	for kind := range pr.Notifications {
+		if indexOf(kind, notificationKinds) < 0 {
+			return errors.New("unknown notification: " + kind)
+		}
+	}
+	return nil
+}
+
+// Bind binds the http req to verifyEmailRequest type as the render
+func (ver *VerifyEmailRequest) Bind(r *http.Request) error {
+	if ver.Token == "" {
+		return errors.New("missing email verification token")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (pr *ProfileResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewProfileResponse creates the client response of a user's profile
+func NewProfileResponse(u User) *ProfileResponse {
+	notifications := make(map[string]bool)
+	for _, kind := range notificationKinds {
+		notifications[kind] = u.Notifies(kind)
+	}
+	return &ProfileResponse{u.ID.Hex(), u.Email, u.PendingEmail, u.FirstName, u.LastName, u.Phone, u.Locale, u.TimeZone, notifications}
+}
+
+// Notifies checks whether or not the user wants a kind of notification
+func (u User) Notifies(kind string) bool {
+	return indexOf(kind, u.MutedNotifications) < 0
+}
+
+// notifies checks whether or not the user with an email wants a kind of notification. Unknown users are notified
+func notifies(email, kind string) bool {
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"email": email}); err != nil {
+		return true
+	}
+	return u.Notifies(kind)
+}
+
+/*renameSet sets every unit owner and pick order a schedule holds under one email to another. The schedule's
+participants and trades are renamed with array filters by ChangeUserEmail */
+func (ms *MasterSchedule) renameSet(from, to string) bson.M {
+	set := bson.M{}
+	for id, smu := range ms.ScheduleUnitMap {
+		if smu.Owner == from && len(smu.MapIndicies) == 3 {
+			for path, owner := range unitOwnerSet(id, smu.MapIndicies, to) {
+				set[path] = owner
+			}
+		}
+	}
+	for i, po := range ms.PickOrders {
+		for block, order := range po.Blocks {
+			for k, email := range order {
+				if email == from {
+					set["pickOrders."+strconv.Itoa(i)+".blocks."+block+"."+strconv.Itoa(k)] = to
+				}
+			}
+		}
+	}
+	return set
+}
+
+/*createEmailTokenString creates a token verifying a user owns a new email address. It is signed with its
+own key so it cannot be used to sign in */
+func createEmailTokenString(userID, email string, expiresIn time.Duration) string {
+	_, tokenString, _ := emailTokenAuth.Encode(jwt.MapClaims{"verifyUserID": userID, "email": email, "exp": jwtauth.ExpireIn(expiresIn)})
+	return tokenString
+}
+
+////////////  CONTROLLERS ////////////////////
+
+// GetProfile gets the requesting user's profile
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"_id": requestUserID(r)}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewProfileResponse(*u))
+}
+
+/*UpdateProfile edits the requesting user's profile. A new email address is only pending until
+the user follows the verification link sent to it */
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	data := &ProfileRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"_id": requestUserID(r)}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	set := bson.M{}
+	if data.FirstName != nil {
+		set["firstName"], u.FirstName = *data.FirstName, *data.FirstName
+	}
+	if data.LastName != nil {
+		set["lastName"], u.LastName = *data.LastName, *data.LastName
+	}
+	if data.Phone != nil {
+		set["phone"], u.Phone = *data.Phone, *data.Phone
+	}
+	if data.Locale != nil {
+		set["locale"], u.Locale = *data.Locale, *data.Locale
+	}
+	if data.TimeZone != nil {
+		set["timeZone"], u.TimeZone = *data.TimeZone, *data.TimeZone
+	}
+	if len(data.Notifications) > 0 {
+		muted := make([]string, 0)
+		for _, kind := range notificationKinds {
+			if on, ok := data.Notifications[kind]; (ok && !on) || (!ok && !u.Notifies(kind)) {
+				muted = append(muted, kind)
+			}
+		}
+		set["mutedNotifications"], u.MutedNotifications = muted, muted
+	}
+	verify := data.Email != nil && *data.Email != u.Email
+	if verify {
+		if err := mh.GetUser(&User{}, bson.M{"email": *data.Email}); err == nil {
+			render.Render(w, r, ErrConflict(errors.New("email "+*data.Email+" already registered")))
+			return
+		}
+		set["pendingEmail"], u.PendingEmail = *data.Email, *data.Email
+	}
+	if len(set) > 0 {
+		if err := mh.UpdateUsers(bson.M{"_id": u.ID}, bson.M{"$set": set}); err != nil {
+			render.Render(w, r, ErrServer(err))
+			return
+		}
+	}
+	if verify {
+		token := createEmailTokenString(u.ID.Hex(), u.PendingEmail, 24*time.Hour)
+		go jdchaimailer.SendEmailVerification(u.PendingEmail, clientBaseURL+"verify-email?token="+token)
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewProfileResponse(*u))
+}
+
+/*VerifyEmail changes the requesting user's email to their pending one, given the token of the verification
+link sent to it. The user is renamed in every schedule version and trade of their groups, deleted groups included */
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	data := &VerifyEmailRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"_id": requestUserID(r)}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	token, err := emailTokenAuth.Decode(data.Token)
+	if err != nil || !token.Valid {
+		render.Render(w, r, ErrForbidden(errors.New("email verification link is invalid or has expired")))
+		return
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	email, _ := claims["email"].(string)
+	if claims["verifyUserID"] != u.ID.Hex() || email == "" || email != u.PendingEmail {
+		render.Render(w, r, ErrForbidden(errors.New("email verification link is invalid or has been replaced")))
+		return
+	}
+	if err = mh.GetUser(&User{}, bson.M{"email": email}); err == nil {
+		render.Render(w, r, ErrConflict(errors.New("email "+email+" already registered")))
+		return
+	}
+	if err = mh.ChangeUserEmail(u.ID, u.Email, email); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	u.Email, u.PendingEmail = email, ""
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewProfileResponse(*u))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Resource is a property shared by a group (a cabin, a boat). Each resource has its own master schedule
+type Resource struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Kind      string             `json:"kind" bson:"kind"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// ResourceRequest is a request by a group admin to add a resource
+type ResourceRequest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// ResourceResponse is the client response for a resource
+type ResourceResponse struct {
+	Resource Resource `json:"resource"`
+}
+
+// ResourcesResponse lists a group's resources
+type ResourcesResponse struct {
+	Resources []Resource `json:"resources"`
+}
+
+// Bind binds the http req to resourceRequest type as the render
+func (rr *ResourceRequest) Bind(r *http.Request) error {
+	if rr.Name == "" {
+		return errors.New("resource must have a name")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (rr *ResourceResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (rr *ResourcesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// HasResource checks whether or not a resource belongs to a group
+func (g Group) HasResource(rid primitive.ObjectID) bool {
+	for _, res := range g.Resources {
+		if res.ID == rid {
+			return true
+		}
+	}
+	return false
+}
+
+/*resourceID parses a resource id of the group. An empty id is the group's default resource,
+the one groups have before adding any resources */
+func (g Group) resourceID(rid string) (primitive.ObjectID, error) {
+	if rid == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(rid)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !g.HasResource(id) {
+		return primitive.NilObjectID, errors.New("resource does not belong to group")
+	}
+	return id, nil
+}
+
+// masterScheduleFilter matches the master schedules of one resource of a group
+func masterScheduleFilter(groupID, resourceID primitive.ObjectID) bson.M {
+	if resourceID.IsZero() {
+		return bson.M{"groupId": groupID, "resourceId": bson.M{"$exists": false}}
+	}
+	return bson.M{"groupId": groupID, "resourceId": resourceID}
+}
+
+/*groupScheduleFilter matches the master schedules of the resource named by the request's
+?resource= query parameter, or the group's default resource */
+func groupScheduleFilter(r *http.Request, g Group) (bson.M, error) {
+	rid, err := g.resourceID(r.URL.Query().Get("resource"))
+	if err != nil {
+		return nil, err
+	}
+	return masterScheduleFilter(g.ID, rid), nil
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetResources lists a group's resources
+func GetResources(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	resources := g.Resources
+	if resources == nil {
+		resources = make([]Resource, 0)
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &ResourcesResponse{resources})
+}
+
+// CreateResource adds a resource to a group
+func CreateResource(w http.ResponseWriter, r *http.Request) {
+	data := &ResourceRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	for _, res := range g.Resources {
+		if res.Name == data.Name {
+			render.Render(w, r, ErrConflict(errors.New("resource name: "+data.Name+" already exists")))
+			return
+		}
+	}
+	res := Resource{primitive.NewObjectID(), data.Name, data.Kind, time.Now()}
+	if err := mh.UpdateGroup(bson.M{"_id": g.ID}, bson.M{"$push": bson.M{"resources": res}}); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &ResourceResponse{res})
+}
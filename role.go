@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Role is what a user is to a group. Each role can do everything the roles below it can
+type Role string
+
+// Group roles, highest first
+const (
+	OwnerRole     Role = "owner"     // the admin that owns the group
+	AdminRole     Role = "admin"     // manages the group and its members
+	SchedulerRole Role = "scheduler" // a member that manages the group's schedules
+	MemberRole    Role = "member"    // owns units, trades and books stays
+	ViewerRole    Role = "viewer"    // a member that can only read the group
+	ObserverRole  Role = "observer"  // not a member, only sees the schedule and announcements
+)
+
+var roleRanks = map[Role]int{ObserverRole: 1, ViewerRole: 2, MemberRole: 3, SchedulerRole: 4, AdminRole: 5, OwnerRole: 6}
+
+// Permission is something a user can do in a group
+type Permission int
+
+// Group permissions
+const (
+	ObserveGroup   Permission = iota // read the schedule, its calendar feed and announcements
+	ViewGroup                        // read the group, its schedules and its board
+	UseGroup                         // trade, book, post, log expenses and stays
+	ManageSchedule                   // create, regenerate, extend, import and split schedules and set blackouts
+	ManageGroup                      // invite, remove and assign roles to members and change settings
+	OwnGroup                         // delete the group and transfer it
+)
+
+// permissionRoles is the lowest role that has each permission
+var permissionRoles = map[Permission]Role{
+	ObserveGroup:   ObserverRole,
+	ViewGroup:      ViewerRole,
+	UseGroup:       MemberRole,
+	ManageSchedule: SchedulerRole,
+	ManageGroup:    AdminRole,
+	OwnGroup:       OwnerRole,
+}
+
+type contextKey string
+
+var groupContextKey = contextKey("group")
+
+// RoleRequest is a request by a group admin to set a member's role
+type RoleRequest struct {
+	Role Role `json:"role"`
+}
+
+// RoleResponse is a member's role in a group
+type RoleResponse struct {
+	UserID primitive.ObjectID `json:"userId"`
+	Role   Role               `json:"role"`
+}
+
+// Bind binds the http req to roleRequest type as the render
+func (rr *RoleRequest) Bind(r *http.Request) error {
+	switch rr.Role {
+	case SchedulerRole, MemberRole, ViewerRole:
+		return nil
+	case AdminRole, OwnerRole:
+		return errors.New("admins and owners are set by promotion and ownership transfer")
+	}
+	return errors.New("role should be scheduler, member or viewer")
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (rr *RoleResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Role is a user's role in the group. It is false for users outside the group
+func (g Group) Role(uid primitive.ObjectID) (Role, bool) {
+	switch {
+	case g.Owner() == uid:
+		return OwnerRole, true
+	case g.HasAdmin(uid):
+		return AdminRole, true
+	case g.HasUser(uid):
+		if role, ok := g.Roles[uid.Hex()]; ok {
+			return role, true
+		}
+		return MemberRole, true
+	case g.HasObserver(uid):
+		return ObserverRole, true
+	}
+	return "", false
+}
+
+// Can checks whether or not a user has a permission in the group
+func (g Group) Can(uid primitive.ObjectID, p Permission) bool {
+	role, ok := g.Role(uid)
+	return ok && roleRanks[role] >= roleRanks[permissionRoles[p]]
+}
+
+// requestUserID is the id of the user making the request
+func requestUserID(r *http.Request) primitive.ObjectID {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	id, _ := claims["userID"].(string)
+	uid, _ := primitive.ObjectIDFromHex(id)
+	return uid
+}
+
+// findGroup loads the group of a request, a variable so the store can be stubbed
+var findGroup = func(g *Group, filter interface{}) error { return mh.GetGroup(g, filter) }
+
+// maxGroupBody bounds the json bodies read to find the group of a request
+const maxGroupBody = 1 << 20
+
+/*requestGroupID finds the group a request is for, from the route or else from the groupId of a
+json body. The body is restored so handlers can still bind it */
+func requestGroupID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, error) {
+	if gid := chi.URLParam(r, "groupID"); gid != "" {
+		return primitive.ObjectIDFromHex(gid)
+	}
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return primitive.NilObjectID, errors.New("request does not name a group")
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGroupBody))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	data := struct {
+		GroupID string `json:"groupId"`
+	}{}
+	if err = json.Unmarshal(body, &data); err != nil || data.GroupID == "" {
+		return primitive.NilObjectID, errors.New("request does not name a group")
+	}
+	return primitive.ObjectIDFromHex(data.GroupID)
+}
+
+// Authorize only lets requests through when the user has a permission in the request's group
+func Authorize(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			groupID, err := requestGroupID(w, r)
+			if err != nil {
+				render.Render(w, r, ErrInvalidRequest(err))
+				return
+			}
+			g := &Group{}
+			if err = findGroup(g, bson.M{"_id": groupID}); err != nil {
+				render.Render(w, r, ErrNotFound(err))
+				return
+			}
+			if !g.Can(requestUserID(r), p) {
+				render.Render(w, r, ErrForbidden(errors.New("not authorized for this group")))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), groupContextKey, g)))
+		})
+	}
+}
+
+// requestGroup gets the group Authorize found for the request and the requesting user
+func requestGroup(r *http.Request) (*Group, primitive.ObjectID) {
+	g, _ := r.Context().Value(groupContextKey).(*Group)
+	return g, requestUserID(r)
+}
+
+////////////  CONTROLLERS //////////////////
+
+// SetMemberRole makes a group member a scheduler, a viewer or a plain member
+func SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	data := &RoleRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	uid, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if !g.HasUser(uid) || g.HasAdmin(uid) {
+		render.Render(w, r, ErrInvalidRequest(errors.New("roles can only be set for members that are not admins")))
+		return
+	}
+	update := bson.M{"$set": bson.M{"roles." + uid.Hex(): data.Role}}
+	if data.Role == MemberRole {
+		update = bson.M{"$unset": bson.M{"roles." + uid.Hex(): ""}}
+	}
+	if _, err = mh.UpdateGroupIf(bson.M{"_id": g.ID}, update); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &RoleResponse{uid, data.Role})
+}
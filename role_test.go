@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// crossGroupRouter routes requests against stubbed groups, returning a member of each of two groups
+func crossGroupRouter(t *testing.T) (http.Handler, *Group, *Group) {
+	tokenAuth = jwtauth.New("HS256", []byte("test secret"), nil)
+	a := &Group{ID: primitive.NewObjectID(), Name: "a", Members: []primitive.ObjectID{primitive.NewObjectID()}}
+	b := &Group{ID: primitive.NewObjectID(), Name: "b", Members: []primitive.ObjectID{primitive.NewObjectID()}}
+	a.Admins, b.Admins = a.Members, b.Members
+
+	stored := findGroup
+	findGroup = func(g *Group, filter interface{}) error {
+		for _, sg := range []*Group{a, b} {
+			if filter.(bson.M)["_id"] == sg.ID {
+				*g = *sg
+				return nil
+			}
+		}
+		return errors.New("no group")
+	}
+	t.Cleanup(func() { findGroup = stored })
+	return newRouter(), a, b
+}
+
+func requestAs(h http.Handler, uid primitive.ObjectID, method, path string) *httptest.ResponseRecorder {
+	_, token, _ := tokenAuth.Encode(jwt.MapClaims{"userID": uid.Hex(), "exp": jwtauth.ExpireIn(60e9)})
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCrossGroupReadsForbidden(t *testing.T) {
+	h, a, b := crossGroupRouter(t)
+	outsider := b.Members[0]
+	for _, path := range []string{
+		"/group/" + a.ID.Hex() + "/user",
+		"/schedule/master/" + a.ID.Hex(),
+	} {
+		if w := requestAs(h, outsider, http.MethodGet, path); w.Code != http.StatusForbidden {
+			t.Errorf("GET %s by a member of another group: got %d, want 403", path, w.Code)
+		}
+	}
+}
+
+func TestOtherUserTradesForbidden(t *testing.T) {
+	h, a, b := crossGroupRouter(t)
+	path := "/user/" + a.Members[0].Hex() + "/trade"
+	if w := requestAs(h, b.Members[0], http.MethodGet, path); w.Code != http.StatusForbidden {
+		t.Errorf("GET %s by another user: got %d, want 403", path, w.Code)
+	}
+}
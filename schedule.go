@@ -3,16 +3,19 @@ package main
 import (
 	"errors"
 	"net/http"
+	"sort"
 	"time"
 
 	jdscheduler "github.com/ede0m/jdgoscheduler"
-	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth"
 	"github.com/go-chi/render"
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// unitDays is the length of a jdscheduler unit
+const unitDays = 7
+
 // MasterSchedule is a jdscheudler output that is returned to all users
 type MasterSchedule struct {
 	ID              primitive.ObjectID         `json:"id" bson:"_id,omitempty"`
@@ -21,34 +24,85 @@ type MasterSchedule struct {
 	TradeLedger     []Trade                    `json:"tradeLedger" bson:"tradeLedger"`
 	CreatedAt       time.Time                  `json:"createdAt" bson:"createdAt"`
 	GroupID         primitive.ObjectID         `json:"groupId" bson:"groupId"`
+	PickOrders      []InferredPickOrder        `json:"pickOrders" bson:"pickOrders"`
+	ResourceID      primitive.ObjectID         `json:"resourceId" bson:"resourceId,omitempty"` // nil for the group's default resource
+	ArchivedAt      time.Time                  `json:"archivedAt" bson:"archivedAt,omitempty"` // set while the group is deleted
+}
 
-	// TODO persist pick orders
+/*InferredPickOrder is the order participants picked in each block type of one season, inferred from
+the order they first own a unit in each block of the generated season. jdscheduler keeps its pick order
+state to itself, so this is not that state but what it produced */
+type InferredPickOrder struct {
+	Season int                 `json:"season" bson:"season"`
+	Blocks map[string][]string `json:"blocks" bson:"blocks"`
 }
 
 // ScheduleMapUnit is a value of the MasterSchedule's OwnerMap
 type ScheduleMapUnit struct {
-	Owner       string    `json:"owner" bson:"owner"`
-	Start       time.Time `json:"start" bson:"start"`
-	MapIndicies []int     `json:"mapIndicies" bson:"mapIndicies"`
+	Owner       string         `json:"owner" bson:"owner"`
+	Start       time.Time      `json:"start" bson:"start"`
+	MapIndicies []int          `json:"mapIndicies" bson:"mapIndicies"`
+	End         time.Time      `json:"end,omitempty" bson:"end,omitempty"`           // set on the parts of a split unit
+	ParentID    string         `json:"parentId,omitempty" bson:"parentId,omitempty"` // the unit a part was split from
+	Bookings    []GuestBooking `json:"bookings,omitempty" bson:"bookings,omitempty"`
+	Stay        *Stay          `json:"stay,omitempty" bson:"stay,omitempty"`
+}
+
+// EndDate is the day a unit ends (its check-out day)
+func (smu ScheduleMapUnit) EndDate() time.Time {
+	if !smu.End.IsZero() {
+		return smu.End
+	}
+	return smu.Start.AddDate(0, 0, unitDays)
+}
+
+// UnitResponse is a unit with its check-in and check-out instants in the group's time zone
+type UnitResponse struct {
+	Owner    string         `json:"owner"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	ParentID string         `json:"parentId,omitempty"`
+	Bookings []GuestBooking `json:"bookings,omitempty"`
 }
 
 // MasterScheduleResponse is the response payload for MasterSchedule data model.
 type MasterScheduleResponse struct {
-	ID        primitive.ObjectID   `json:"id"`
-	Schedule  jdscheduler.Schedule `json:"schedule"`
-	CreatedAt time.Time            `json:"createdAt"`
-	GroupID   primitive.ObjectID   `json:"groupId" `
+	ID         primitive.ObjectID      `json:"id"`
+	Schedule   jdscheduler.Schedule    `json:"schedule"`
+	CreatedAt  time.Time               `json:"createdAt"`
+	GroupID    primitive.ObjectID      `json:"groupId" `
+	PickOrders []InferredPickOrder     `json:"pickOrders"`
+	ResourceID primitive.ObjectID      `json:"resourceId"`
+	TimeZone   string                  `json:"timeZone"`
+	Units      map[string]UnitResponse `json:"units"`
 }
 
 // ScheduleResponse is the request payload for Scheudle data model.
 type ScheduleResponse struct {
 	Schedule jdscheduler.Schedule `json:"schedule"`
+	Scores   map[string]float64   `json:"scores,omitempty"` // participant value scores when weights are given
 }
 
 // MasterScheduleRequest is the request payload for creating master schedules for a group
 type MasterScheduleRequest struct {
-	Schedule jdscheduler.Schedule `json:"schedule"`
-	GroupID  string               `json:"groupId"`
+	Schedule   jdscheduler.Schedule `json:"schedule"`
+	GroupID    string               `json:"groupId"`
+	ResourceID string               `json:"resourceId"` // empty for the group's default resource
+}
+
+// RegenerateScheduleRequest is the request payload for regenerating a group's master schedule
+type RegenerateScheduleRequest struct {
+	GroupID      string    `json:"groupId"`
+	ResourceID   string    `json:"resourceId"` // empty for the group's default resource
+	Start        time.Time `json:"start"`
+	SeasonUnits  int       `json:"seasonUnits"`
+	Years        int       `json:"years"`
+	Participants []string  `json:"participants"` // defaults to the current schedule's participants
+}
+
+// ExtendScheduleRequest is the request payload for appending seasons to a master schedule
+type ExtendScheduleRequest struct {
+	Years int `json:"years"`
 }
 
 // ScheduleRequest is the request payload for generating schedules against the jdscheduler module
@@ -57,6 +111,9 @@ type ScheduleRequest struct {
 	SeasonUnits  int
 	Years        int
 	Participants []string
+	Weights      []WeightedRange // value of holidays, peak weeks etc.
+	FairnessGoal string          // none or balanced
+	Blackouts    []DateRange     // periods without units
 }
 
 // NewMasterSchedule creates a new master schedule
@@ -66,19 +123,42 @@ func NewMasterSchedule(sch jdscheduler.Schedule, groupID primitive.ObjectID) (*M
 	for i, s := range sch.Seasons {
 		for j, b := range s.Blocks {
 			for k, unit := range b.Units {
-				scm := ScheduleMapUnit{unit.Participant, unit.Start, []int{i, j, k}}
+				scm := ScheduleMapUnit{Owner: unit.Participant, Start: unit.Start, MapIndicies: []int{i, j, k}}
 				ownerMap[unit.ID.String()] = scm
 			}
 		}
 	}
-	// TODO: get scheudle's scheudler pick order state, create trade log
-	ms := &MasterSchedule{primitive.NilObjectID, sch, ownerMap, []Trade{}, time.Now(), groupID}
+	ms := &MasterSchedule{primitive.NilObjectID, sch, ownerMap, []Trade{}, time.Now(), groupID, inferPickOrders(sch), primitive.NilObjectID, time.Time{}}
 	return ms, nil
 }
 
+// inferPickOrders infers each season's pick orders from the order participants first own a unit in each block
+func inferPickOrders(sch jdscheduler.Schedule) []InferredPickOrder {
+	orders := make([]InferredPickOrder, 0)
+	for _, s := range sch.Seasons {
+		po := InferredPickOrder{s.OpenWeek.Year(), make(map[string][]string)}
+		for _, b := range s.Blocks {
+			bt := b.BlockType.String()
+			for _, u := range b.Units {
+				if u.Participant != "" && indexOf(u.Participant, po.Blocks[bt]) < 0 {
+					po.Blocks[bt] = append(po.Blocks[bt], u.Participant)
+				}
+			}
+		}
+		orders = append(orders, po)
+	}
+	return orders
+}
+
 // NewMasterScheduleResponse creates a new master schedule
-func NewMasterScheduleResponse(ms MasterSchedule) *MasterScheduleResponse {
-	msr := &MasterScheduleResponse{ID: ms.ID, Schedule: ms.Schedule, CreatedAt: ms.CreatedAt, GroupID: ms.GroupID}
+func NewMasterScheduleResponse(ms MasterSchedule, g Group) *MasterScheduleResponse {
+	msr := &MasterScheduleResponse{ID: ms.ID, Schedule: ms.Schedule, CreatedAt: ms.CreatedAt, GroupID: ms.GroupID, PickOrders: ms.PickOrders, ResourceID: ms.ResourceID}
+	msr.TimeZone = g.Location().String()
+	msr.Units = make(map[string]UnitResponse)
+	for id, smu := range ms.ScheduleUnitMap {
+		start, end := g.UnitBounds(smu.Start, smu.EndDate())
+		msr.Units[id] = UnitResponse{smu.Owner, start, end, smu.ParentID, smu.Bookings}
+	}
 	return msr
 }
 
@@ -97,7 +177,16 @@ func (rd *ScheduleResponse) Render(w http.ResponseWriter, r *http.Request) error
 
 // Render is called in top-down order, like a http handler middleware chain.
 func (rd *MasterScheduleResponse) Render(w http.ResponseWriter, r *http.Request) error {
-	// Pre-processing before a response is marshalled and sent across the wire
+	// guest contacts are only sent to the unit's owner and group admins
+	g, uid := requestGroup(r)
+	if !rd.hasBookings() || (g != nil && g.Can(uid, ManageGroup)) {
+		return nil
+	}
+	u := &User{}
+	if err := mh.GetUser(u, bson.M{"_id": uid}); err != nil {
+		u.Email = ""
+	}
+	rd.hideContacts(u.Email)
 	return nil
 }
 
@@ -107,8 +196,8 @@ func (sr *ScheduleRequest) Bind(r *http.Request) error {
 	if sr.Start.IsZero() || sr.Participants == nil {
 		return errors.New("missing required StartDate, Participants fields")
 	}
-	// remove hh::mm::ss
-	sr.Start = time.Date(sr.Start.Year(), sr.Start.Month(), sr.Start.Day(), 0, 0, 0, 0, sr.Start.Location())
+	// keep the calendar day the client sent. units are days, check-in times come from the group
+	sr.Start = time.Date(sr.Start.Year(), sr.Start.Month(), sr.Start.Day(), 0, 0, 0, 0, time.UTC)
 	// defaults
 	if sr.Years == 0 {
 		sr.Years = 5
@@ -116,6 +205,20 @@ func (sr *ScheduleRequest) Bind(r *http.Request) error {
 	if sr.SeasonUnits == 0 {
 		sr.SeasonUnits = 3
 	}
+	if sr.FairnessGoal == "" {
+		sr.FairnessGoal = NoFairnessGoal
+	}
+	if sr.FairnessGoal != NoFairnessGoal && sr.FairnessGoal != BalancedFairnessGoal {
+		return errors.New("fairness goal should be none or balanced")
+	}
+	if err := validateWeights(sr.Weights); err != nil {
+		return err
+	}
+	for _, b := range sr.Blackouts {
+		if err := b.validate(); err != nil {
+			return err
+		}
+	}
 
 	// TODO: handle user
 
@@ -132,6 +235,289 @@ func (msr *MasterScheduleRequest) Bind(r *http.Request) error {
 	return nil
 }
 
+// Bind binds the http req to regenerateScheduleRequest type as the render
+func (rsr *RegenerateScheduleRequest) Bind(r *http.Request) error {
+	if rsr.GroupID == "" {
+		return errors.New("must bind schedule to group")
+	}
+	if rsr.Start.IsZero() {
+		return errors.New("missing required start field")
+	}
+	rsr.Start = time.Date(rsr.Start.Year(), rsr.Start.Month(), rsr.Start.Day(), 0, 0, 0, 0, time.UTC)
+	if rsr.Years == 0 {
+		rsr.Years = 5
+	}
+	if rsr.SeasonUnits == 0 {
+		rsr.SeasonUnits = 3
+	}
+	return nil
+}
+
+// Bind binds the http req to extendScheduleRequest type as the render
+func (esr *ExtendScheduleRequest) Bind(r *http.Request) error {
+	if esr.Years <= 0 {
+		return errors.New("must extend by at least one year")
+	}
+	return nil
+}
+
+/*Extend appends years of seasons after the last season of the schedule, continuing the rotation
+from where the last season ended. Units in blackouts are skipped. Existing seasons, unit ids and trades are untouched.
+It returns the appended seasons with their pick orders and schedule unit map entries. */
+func (ms *MasterSchedule) Extend(years int, blackouts []DateRange) ([]*jdscheduler.Season, []InferredPickOrder, map[string]ScheduleMapUnit, error) {
+
+	seasons := ms.Schedule.Seasons
+	if len(seasons) == 0 {
+		return nil, nil, nil, errors.New("schedule has no seasons to extend")
+	}
+	first, last := seasons[0].OpenWeek, seasons[len(seasons)-1].OpenWeek
+	start := time.Date(last.Year()+1, first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+
+	sch, err := jdscheduler.NewSchedule(start, years, ms.Schedule.UnitsPerSeason, ms.pickOrderFor(start.Year()))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	orders := inferPickOrders(*sch)
+	RemoveBlackoutUnits(sch, blackouts)
+
+	added := make(map[string]ScheduleMapUnit)
+	offset := len(seasons)
+	for i, s := range sch.Seasons {
+		for j, b := range s.Blocks {
+			for k, unit := range b.Units {
+				added[unit.ID.String()] = ScheduleMapUnit{Owner: unit.Participant, Start: unit.Start, MapIndicies: []int{offset + i, j, k}}
+			}
+		}
+	}
+	ms.Schedule.Seasons = append(ms.Schedule.Seasons, sch.Seasons...)
+	ms.Schedule.Years += years
+	for id, smu := range added {
+		ms.ScheduleUnitMap[id] = smu
+	}
+	ms.PickOrders = append(ms.PickOrders, orders...)
+	return sch.Seasons, orders, added, nil
+}
+
+/*pickOrderFor is the participant order a season of the given year starts with. Seasons already in the
+schedule use their inferred pick order. Later seasons continue the rotation: the last season's first
+pick order, rotated by one for every year after it. */
+func (ms *MasterSchedule) pickOrderFor(year int) []string {
+	participants := ms.Schedule.Participants
+	orders := ms.PickOrders
+	if len(orders) == 0 {
+		// schedules stored before pick orders were persisted. inferred from current owners, so trades change it
+		orders = inferPickOrders(ms.Schedule)
+	}
+	if len(orders) == 0 {
+		return participants
+	}
+	last := orders[len(orders)-1]
+	for _, po := range orders {
+		if po.Season == year {
+			return po.firstOrder(participants)
+		}
+	}
+	order := last.firstOrder(participants)
+	if n := len(order); n > 0 && year > last.Season {
+		return rotate(order, (year-last.Season)%n)
+	}
+	return participants
+}
+
+/*firstOrder is the pick order of the season's first block type. Participants that never picked
+in it go last */
+func (po InferredPickOrder) firstOrder(participants []string) []string {
+	var order []string
+	for _, bt := range []jdscheduler.BlockType{jdscheduler.Opening, jdscheduler.Prime, jdscheduler.Closing, jdscheduler.None} {
+		if picks, ok := po.Blocks[bt.String()]; ok {
+			order = picks
+			break
+		}
+	}
+	var ret []string
+	for _, p := range order {
+		if indexOf(p, participants) >= 0 {
+			ret = append(ret, p)
+		}
+	}
+	for _, p := range participants {
+		if indexOf(p, ret) < 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// rotate returns order rotated left by n steps as a new slice
+func rotate(order []string, n int) []string {
+	ret := make([]string, 0, len(order))
+	ret = append(ret, order[n:]...)
+	return append(ret, order[:n]...)
+}
+
+/*Regenerate builds a new master schedule version from a freshly generated schedule, leaving out units in blackouts.
+Units whose start and end dates already exist keep their id, current owner, bookings and stay, and units that were
+split keep their parts. Executed trades are replayed onto the units whose dates moved. Open trades
+stay open only if all of their units still exist, otherwise they are void */
+func (ms *MasterSchedule) Regenerate(sch jdscheduler.Schedule, blackouts []DateRange) (*MasterSchedule, error) {
+
+	// pick orders are inferred from the generated units, before blackouts and carried over ownership
+	orders := inferPickOrders(sch)
+	RemoveBlackoutUnits(&sch, blackouts)
+	existing, splits := ms.unitSpans()
+	carried := make(map[string]bool)
+	for _, s := range sch.Seasons {
+		for j := range s.Blocks {
+			units := make([]jdscheduler.Unit, 0, len(s.Blocks[j].Units))
+			for _, unit := range s.Blocks[j].Units {
+				span := spanOf(unit.Start, unit.Start.AddDate(0, 0, unitDays))
+				if id, ok := existing[span]; ok {
+					unit.ID, unit.Participant = uuid.MustParse(id), ms.ScheduleUnitMap[id].Owner
+					carried[id] = true
+				} else if parts, ok := splits[span]; ok {
+					for _, pid := range parts {
+						part := ms.ScheduleUnitMap[pid]
+						units = append(units, jdscheduler.Unit{ID: uuid.MustParse(pid), Start: part.Start, Participant: part.Owner})
+						carried[pid] = true
+					}
+					continue
+				}
+				units = append(units, unit)
+			}
+			s.Blocks[j].Units = units
+		}
+	}
+
+	regen, err := NewMasterSchedule(sch, ms.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	regen.PickOrders = orders
+	regen.ResourceID = ms.ResourceID
+	for id := range carried {
+		smu, old := regen.ScheduleUnitMap[id], ms.ScheduleUnitMap[id]
+		smu.End, smu.ParentID, smu.Bookings, smu.Stay = old.End, old.ParentID, old.Bookings, old.Stay
+		regen.ScheduleUnitMap[id] = smu
+	}
+	for _, t := range ms.TradeLedger {
+		if t.Status == Executed {
+			regen.replayTrade(ms, t, carried)
+		}
+	}
+	for _, t := range ms.TradeLedger {
+		if t.Status == Open && !regen.hasTradeUnits(t) {
+			t.Status = Void
+		}
+		regen.TradeLedger = append(regen.TradeLedger, t)
+	}
+	return regen, nil
+}
+
+// unitSpan is the start and end day of a unit
+type unitSpan struct {
+	start, end int64
+}
+
+func spanOf(start, end time.Time) unitSpan {
+	return unitSpan{start.Unix(), end.Unix()}
+}
+
+/*unitSpans indexes the schedule's units by their span, and the parts of split units, in date order,
+by the span of the unit they were split from */
+func (ms *MasterSchedule) unitSpans() (map[unitSpan]string, map[unitSpan][]string) {
+	ids := make([]string, 0, len(ms.ScheduleUnitMap))
+	for id := range ms.ScheduleUnitMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ms.ScheduleUnitMap[ids[i]], ms.ScheduleUnitMap[ids[j]]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return ids[i] < ids[j]
+	})
+	units := make(map[unitSpan]string)
+	parts := make(map[string][]string)
+	for _, id := range ids {
+		smu := ms.ScheduleUnitMap[id]
+		if smu.ParentID != "" {
+			parts[smu.ParentID] = append(parts[smu.ParentID], id)
+			continue
+		}
+		if span := spanOf(smu.Start, smu.EndDate()); units[span] == "" {
+			units[span] = id
+		}
+	}
+	splits := make(map[unitSpan][]string)
+	for _, pids := range parts {
+		first, last := ms.ScheduleUnitMap[pids[0]], ms.ScheduleUnitMap[pids[len(pids)-1]]
+		splits[spanOf(first.Start, last.EndDate())] = pids
+	}
+	return units, splits
+}
+
+/*replayTrade gives the units of an executed trade of a previous version to their new owners, where
+the units were regenerated at new dates. Carried over units already have their traded owner and units
+of other resources' schedules are left to those schedules */
+func (ms *MasterSchedule) replayTrade(prev *MasterSchedule, t Trade, carried map[string]bool) {
+	replay := func(units []TradeUnit, owner string) {
+		for _, tu := range units {
+			if carried[tu.ID.String()] || !tu.ScheduleID.IsZero() {
+				continue
+			}
+			end := tu.UnitStart.AddDate(0, 0, unitDays)
+			if smu, ok := prev.ScheduleUnitMap[tu.ID.String()]; ok {
+				end = smu.EndDate()
+			}
+			if id, ok := ms.movedUnit(tu.UnitStart, end, carried); ok {
+				ms.setOwner(id, owner)
+			}
+		}
+	}
+	replay(t.InitiatorTrades, t.ExecutorEmail)
+	replay(t.ExecutorTrades, t.InitiatorEmail)
+}
+
+// movedUnit finds the regenerated unit, other than carried over units, that overlaps a unit's old dates the most
+func (ms *MasterSchedule) movedUnit(start, end time.Time, carried map[string]bool) (string, bool) {
+	best, most := "", time.Duration(0)
+	for _, s := range ms.Schedule.Seasons {
+		for _, b := range s.Blocks {
+			for _, u := range b.Units {
+				id := u.ID.String()
+				if carried[id] {
+					continue
+				}
+				from, to := u.Start, ms.ScheduleUnitMap[id].EndDate()
+				if start.After(from) {
+					from = start
+				}
+				if end.Before(to) {
+					to = end
+				}
+				if d := to.Sub(from); d > most {
+					best, most = id, d
+				}
+			}
+		}
+	}
+	return best, most > 0
+}
+
+/*hasTradeUnits checks whether every unit of a trade is in the schedule. Units of other resources'
+schedules are left to those schedules */
+func (ms *MasterSchedule) hasTradeUnits(t Trade) bool {
+	for _, tu := range append(append([]TradeUnit{}, t.InitiatorTrades...), t.ExecutorTrades...) {
+		if !tu.ScheduleID.IsZero() {
+			continue
+		}
+		if _, ok := ms.ScheduleUnitMap[tu.ID.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 ////////////  CONTROLLERS //////////////////
 
 // GenerateSchedule just generates a scheudle with given query parameteres
@@ -147,8 +533,16 @@ func GenerateSchedule(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
+	RemoveBlackoutUnits(s, data.Blackouts)
+	if data.FairnessGoal == BalancedFairnessGoal {
+		BalanceSchedule(s, data.Weights)
+	}
+	resp := NewScheduleResponse(*s)
+	if len(data.Weights) > 0 {
+		resp.Scores = ParticipantScores(*s, data.Weights)
+	}
 	render.Status(r, http.StatusOK)
-	render.Render(w, r, NewScheduleResponse(*s))
+	render.Render(w, r, resp)
 }
 
 // CreateMasterSchedule commits a schedule as master to schedule
@@ -159,29 +553,70 @@ func CreateMasterSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s := data.Schedule
-	// group must exist
-	groupID, err := primitive.ObjectIDFromHex(data.GroupID)
+	g, _ := requestGroup(r)
+	if err := g.checkParticipants(s.Participants); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	resourceID, err := g.resourceID(data.ResourceID)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	g := &Group{}
-	if err = mh.GetGroup(g, bson.M{"_id": groupID}); err != nil {
+	orders := inferPickOrders(s)
+	RemoveBlackoutUnits(&s, g.blackoutRanges(resourceID))
+	ms, err := NewMasterSchedule(s, g.ID)
+	if err != nil {
 		render.Render(w, r, ErrNotFound(err))
 		return
 	}
-	// schedule must be created by admin of group
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	uid, _ := primitive.ObjectIDFromHex(claims["userID"].(string))
-	if ok := g.HasAdmin(uid); !ok {
-		render.Render(w, r, ErrAuth(errors.New("not authorized for this group")))
+	ms.PickOrders, ms.ResourceID = orders, resourceID
+	result, err := mh.InsertMasterSchedule(ms)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	ms.ID = result.InsertedID.(primitive.ObjectID)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, NewMasterScheduleResponse(*ms, *g))
+}
+
+// RegenerateMasterSchedule generates a new master schedule version that preserves the current ownership and trades
+func RegenerateMasterSchedule(w http.ResponseWriter, r *http.Request) {
+	data := &RegenerateScheduleRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	ms, err := NewMasterSchedule(s, groupID)
+	g, _ := requestGroup(r)
+	resourceID, err := g.resourceID(data.ResourceID)
 	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	current := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(current, masterScheduleFilter(g.ID, resourceID)); err != nil {
 		render.Render(w, r, ErrNotFound(err))
 		return
 	}
+	participants := data.Participants
+	if len(participants) == 0 {
+		participants = current.pickOrderFor(data.Start.Year())
+	}
+	if err = g.checkParticipants(participants); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	sch, err := jdscheduler.NewSchedule(data.Start, data.Years, data.SeasonUnits, participants)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms, err := current.Regenerate(*sch, g.blackoutRanges(resourceID))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
 	result, err := mh.InsertMasterSchedule(ms)
 	if err != nil {
 		render.Render(w, r, ErrServer(err))
@@ -189,53 +624,122 @@ func CreateMasterSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 	ms.ID = result.InsertedID.(primitive.ObjectID)
 	render.Status(r, http.StatusCreated)
-	render.Render(w, r, NewMasterScheduleResponse(*ms))
+	render.Render(w, r, NewMasterScheduleResponse(*ms, *g))
 }
 
-// GetMasterSchedule retrieves the current (most recent) master scheudle
+// ExtendMasterSchedule appends seasons to a group's current master schedule in place
+func ExtendMasterSchedule(w http.ResponseWriter, r *http.Request) {
+	data := &ExtendScheduleRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	filter, err := groupScheduleFilter(r, *g)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, filter); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	had := len(ms.Schedule.Seasons)
+	seasons, orders, units, err := ms.Extend(data.Years, g.blackoutRanges(ms.ResourceID))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	appended, err := mh.AppendScheduleSeasons(ms.ID, had, seasons, orders, units)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !appended {
+		render.Render(w, r, ErrConflict(errors.New("schedule was changed while extending it, try again")))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewMasterScheduleResponse(*ms, *g))
+}
+
+// GetMasterSchedule retrieves the current (most recent) master scheudle of a group resource (?resource=id)
 func GetMasterSchedule(w http.ResponseWriter, r *http.Request) {
-	gid := chi.URLParam(r, "groupID")
-	groupID, err := primitive.ObjectIDFromHex(gid)
+	g, _ := requestGroup(r)
+	filter, err := groupScheduleFilter(r, *g)
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 	ms := &MasterSchedule{}
-	err = mh.GetMasterSchedule(ms, bson.M{"groupId": groupID})
+	err = mh.GetMasterSchedule(ms, filter)
 	if err != nil {
 		render.Render(w, r, ErrNotFound(err))
 		return
 	}
 	render.Status(r, http.StatusOK)
-	render.Render(w, r, NewMasterScheduleResponse(*ms))
+	render.Render(w, r, NewMasterScheduleResponse(*ms, *g))
 }
 
-func (ms *MasterSchedule) tradeScheduleUnits(t Trade) (jdscheduler.Schedule, map[string]ScheduleMapUnit) {
+/*setOwner gives a unit to a new owner in both the schedule and the schedule unit map.
+Guest bookings are handed over to the new owner or cancelled */
+func (ms *MasterSchedule) setOwner(id string, owner string) {
+	smu := ms.ScheduleUnitMap[id]
+	smu.Owner = owner
+	smu.Bookings = handOverBookings(smu.Bookings)
+	indicies := smu.MapIndicies
+	if len(indicies) == 3 {
+		ms.Schedule.Seasons[indicies[0]].Blocks[indicies[1]].Units[indicies[2]].Participant = owner
+	} else {
+		panic(errors.New("schedule map unit indicies corrupt"))
+	}
+	ms.ScheduleUnitMap[id] = smu
+}
 
-	sch := ms.Schedule
-	suMap := ms.ScheduleUnitMap
+// unitsSnapshot is a copy of a master schedule's participants, block units and unit map taken before changing them
+type unitsSnapshot struct {
+	participants []string
+	blocks       map[[2]int][]jdscheduler.Unit
+	units        map[string]ScheduleMapUnit
+}
 
-	for _, u := range t.InitiatorTrades {
-		smu := suMap[u.ID.String()]
-		smu.Owner = t.ExecutorEmail
-		indicies := smu.MapIndicies
-		if len(indicies) == 3 {
-			sch.Seasons[indicies[0]].Blocks[indicies[1]].Units[indicies[2]].Participant = t.ExecutorEmail
-		} else {
-			panic(errors.New("schedule map unit indicies corrupt"))
+// snapshot copies the schedule's units so only the ones changed afterwards are stored
+func (ms *MasterSchedule) snapshot() unitsSnapshot {
+	snap := unitsSnapshot{
+		participants: append([]string(nil), ms.Schedule.Participants...),
+		blocks:       make(map[[2]int][]jdscheduler.Unit),
+		units:        make(map[string]ScheduleMapUnit, len(ms.ScheduleUnitMap)),
+	}
+	for i, s := range ms.Schedule.Seasons {
+		for j, b := range s.Blocks {
+			snap.blocks[[2]int{i, j}] = append([]jdscheduler.Unit(nil), b.Units...)
 		}
 	}
+	for id, smu := range ms.ScheduleUnitMap {
+		snap.units[id] = smu
+	}
+	return snap
+}
 
-	for _, u := range t.ExecutorTrades {
-		smu := suMap[u.ID.String()]
-		smu.Owner = t.InitiatorEmail
-		indicies := smu.MapIndicies
-		if len(indicies) == 3 {
-			sch.Seasons[indicies[0]].Blocks[indicies[1]].Units[indicies[2]].Participant = t.InitiatorEmail
-		} else {
-			panic(errors.New("schedule map unit indicies corrupt"))
+// ownerEmails returns the distinct owners of every unit in the schedule
+func (ms *MasterSchedule) ownerEmails() []string {
+	seen := make(map[string]bool)
+	var emails []string
+	for _, smu := range ms.ScheduleUnitMap {
+		if smu.Owner != "" && !seen[smu.Owner] {
+			seen[smu.Owner] = true
+			emails = append(emails, smu.Owner)
 		}
 	}
+	return emails
+}
 
-	return sch, suMap
+func indexOf(str string, s []string) int {
+	for i, v := range s {
+		if str == v {
+			return i
+		}
+	}
+	return -1
 }
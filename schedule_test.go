@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	jdscheduler "github.com/ede0m/jdgoscheduler"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRegenerateKeepsCrossResourceTradesOpen(t *testing.T) {
+	start := time.Date(2021, time.May, 1, 0, 0, 0, 0, time.UTC)
+	participants := []string{"a@example.com", "b@example.com"}
+	sch, err := jdscheduler.NewSchedule(start, 1, 4, participants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lake, err := NewMasterSchedule(*sch, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lake unit is offered for a unit of the ski resource's schedule
+	var lakeUnit TradeUnit
+	for id, smu := range lake.ScheduleUnitMap {
+		if smu.Owner == "a@example.com" {
+			lakeUnit = TradeUnit{ID: uuid.MustParse(id), UnitStart: smu.Start}
+			break
+		}
+	}
+	skiUnit := TradeUnit{ID: uuid.New(), UnitStart: start, ScheduleID: primitive.NewObjectID()}
+	lake.TradeLedger = []Trade{{
+		ID:              primitive.NewObjectID(),
+		InitiatorEmail:  "a@example.com",
+		ExecutorEmail:   "b@example.com",
+		InitiatorTrades: []TradeUnit{lakeUnit},
+		ExecutorTrades:  []TradeUnit{skiUnit},
+		Status:          Open,
+	}}
+
+	regen, err := lake.Regenerate(*sch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := regen.TradeLedger[0].Status; got != Open {
+		t.Errorf("lake-for-ski trade after regenerating the lake schedule: got status %d, want open", got)
+	}
+}
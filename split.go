@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	jdscheduler "github.com/ede0m/jdgoscheduler"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SplitUnitRequest is a request by a unit owner to split the unit into parts at the given days
+type SplitUnitRequest struct {
+	At []time.Time `json:"at"` // days the parts after the first one start on
+}
+
+// UnitPartsResponse is the client response for a split or merged unit
+type UnitPartsResponse struct {
+	Units    map[string]ScheduleMapUnit `json:"units"`
+	Schedule *MasterScheduleResponse    `json:"schedule"`
+}
+
+// Bind binds the http req to splitUnitRequest type as the render
+func (sur *SplitUnitRequest) Bind(r *http.Request) error {
+	if len(sur.At) == 0 {
+		return errors.New("must specify at least one day to split at")
+	}
+	for i, at := range sur.At {
+		sur.At[i] = time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+		if i > 0 && !sur.At[i].After(sur.At[i-1]) {
+			return errors.New("split days must be in order")
+		}
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (upr *UnitPartsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+/*SplitUnit replaces a unit with parts starting on its start day and each of the split days.
+Parts get new unit ids and keep the unit's owner. Parts cannot be split again */
+func (ms *MasterSchedule) SplitUnit(id string, at []time.Time) (map[string]ScheduleMapUnit, error) {
+	smu, ok := ms.ScheduleUnitMap[id]
+	if !ok {
+		return nil, errors.New("unit not in schedule")
+	}
+	if smu.ParentID != "" {
+		return nil, errors.New("unit is already a part of a split unit")
+	}
+	if len(smu.MapIndicies) != 3 {
+		return nil, errors.New("schedule map unit indicies corrupt")
+	}
+	if len(smu.Bookings) > 0 {
+		return nil, errors.New("guest bookings must be cancelled before splitting a unit")
+	}
+	if smu.Stay != nil {
+		return nil, errors.New("units with a logged stay cannot be split")
+	}
+	end := smu.EndDate()
+	starts := append([]time.Time{smu.Start}, at...)
+	for _, s := range at {
+		if !s.After(smu.Start) || !s.Before(end) {
+			return nil, errors.New("split days must be within the unit")
+		}
+	}
+
+	parts := make(map[string]ScheduleMapUnit)
+	units := make([]jdscheduler.Unit, 0, len(starts))
+	for i, s := range starts {
+		partEnd := end
+		if i+1 < len(starts) {
+			partEnd = starts[i+1]
+		}
+		u := jdscheduler.Unit{ID: uuid.New(), Start: s, Participant: smu.Owner}
+		units = append(units, u)
+		parts[u.ID.String()] = ScheduleMapUnit{Owner: smu.Owner, Start: s, End: partEnd, ParentID: id}
+	}
+
+	idx := smu.MapIndicies
+	block := &ms.Schedule.Seasons[idx[0]].Blocks[idx[1]]
+	block.Units = spliceUnits(block.Units, idx[2], idx[2]+1, units)
+	for pid, part := range parts {
+		ms.ScheduleUnitMap[pid] = part
+	}
+	ms.reindexUnits()
+	return ms.partsOf(id), nil
+}
+
+/*MergeUnit puts the parts of a split unit back together under the unit's id. Every part must
+still be in the schedule and have the same owner */
+func (ms *MasterSchedule) MergeUnit(parentID string) (map[string]ScheduleMapUnit, error) {
+	parts := ms.partsOf(parentID)
+	if len(parts) == 0 {
+		return nil, errors.New("unit has no parts to merge")
+	}
+	ids := make([]string, 0, len(parts))
+	for pid := range parts {
+		ids = append(ids, pid)
+	}
+	sort.Slice(ids, func(i, j int) bool { return parts[ids[i]].Start.Before(parts[ids[j]].Start) })
+
+	first, last := parts[ids[0]], parts[ids[len(ids)-1]]
+	for i, pid := range ids {
+		if parts[pid].Owner != first.Owner {
+			return nil, errors.New("all parts must have the same owner to merge")
+		}
+		if parts[pid].Stay != nil {
+			return nil, errors.New("parts with a logged stay cannot be merged")
+		}
+		if i > 0 && !parts[ids[i-1]].EndDate().Equal(parts[pid].Start) {
+			return nil, errors.New("parts are missing from the schedule")
+		}
+	}
+	// parts are contiguous in one block, they replaced the unit where it was
+	idx := first.MapIndicies
+	if len(idx) != 3 {
+		return nil, errors.New("schedule map unit indicies corrupt")
+	}
+	block := &ms.Schedule.Seasons[idx[0]].Blocks[idx[1]]
+	unit := jdscheduler.Unit{ID: uuid.MustParse(parentID), Start: first.Start, Participant: first.Owner}
+	block.Units = spliceUnits(block.Units, idx[2], idx[2]+len(ids), []jdscheduler.Unit{unit})
+
+	merged := ScheduleMapUnit{Owner: first.Owner, Start: first.Start}
+	for _, pid := range ids {
+		merged.Bookings = append(merged.Bookings, parts[pid].Bookings...)
+	}
+	if !last.EndDate().Equal(first.Start.AddDate(0, 0, unitDays)) {
+		merged.End = last.EndDate()
+	}
+	for _, pid := range ids {
+		delete(ms.ScheduleUnitMap, pid)
+	}
+	ms.ScheduleUnitMap[parentID] = merged
+	ms.reindexUnits()
+	return map[string]ScheduleMapUnit{parentID: ms.ScheduleUnitMap[parentID]}, nil
+}
+
+// partsOf returns the parts a unit was split into
+func (ms *MasterSchedule) partsOf(parentID string) map[string]ScheduleMapUnit {
+	parts := make(map[string]ScheduleMapUnit)
+	for id, smu := range ms.ScheduleUnitMap {
+		if smu.ParentID == parentID {
+			parts[id] = smu
+		}
+	}
+	return parts
+}
+
+// spliceUnits replaces units[from:to] with the given units
+func spliceUnits(units []jdscheduler.Unit, from, to int, with []jdscheduler.Unit) []jdscheduler.Unit {
+	spliced := make([]jdscheduler.Unit, 0, len(units)-(to-from)+len(with))
+	spliced = append(spliced, units[:from]...)
+	spliced = append(spliced, with...)
+	return append(spliced, units[to:]...)
+}
+
+// unitRequestSchedule gets the authorized group, the requested resource's schedule and the requesting user
+func unitRequestSchedule(r *http.Request) (*Group, *MasterSchedule, *User, render.Renderer) {
+	g, uid := requestGroup(r)
+	filter, err := groupScheduleFilter(r, *g)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidRequest(err)
+	}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, filter); err != nil {
+		return nil, nil, nil, ErrNotFound(err)
+	}
+	u := &User{}
+	if err = mh.GetUser(u, bson.M{"_id": uid}); err != nil {
+		return nil, nil, nil, ErrNotFound(err)
+	}
+	return g, ms, u, nil
+}
+
+////////////  CONTROLLERS //////////////////
+
+// SplitScheduleUnit splits a unit of a group resource's current schedule (?resource=id) into parts
+func SplitScheduleUnit(w http.ResponseWriter, r *http.Request) {
+	data := &SplitUnitRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, ms, u, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	unitID := chi.URLParam(r, "unitID")
+	if smu, ok := ms.ScheduleUnitMap[unitID]; ok && smu.Owner != u.Email {
+		render.Render(w, r, ErrForbidden(errors.New("only the unit owner can split a unit")))
+		return
+	}
+	before := ms.snapshot()
+	parts, err := ms.SplitUnit(unitID, data.At)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	// the unit no longer exists, so its open trades are void
+	if err = mh.UpdateScheduleUnits(ms, before, []uuid.UUID{uuid.MustParse(unitID)}); err == errScheduleChanged {
+		render.Render(w, r, ErrConflict(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &UnitPartsResponse{parts, NewMasterScheduleResponse(*ms, *g)})
+}
+
+// MergeScheduleUnit merges the parts of a split unit back into the unit once they have one owner
+func MergeScheduleUnit(w http.ResponseWriter, r *http.Request) {
+	g, ms, u, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	unitID := chi.URLParam(r, "unitID")
+	parts := ms.partsOf(unitID)
+	voidUnits := make([]uuid.UUID, 0, len(parts))
+	for pid, part := range parts {
+		if part.Owner != u.Email {
+			render.Render(w, r, ErrForbidden(errors.New("only the owner of every part can merge a unit")))
+			return
+		}
+		voidUnits = append(voidUnits, uuid.MustParse(pid))
+	}
+	before := ms.snapshot()
+	merged, err := ms.MergeUnit(unitID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err = mh.UpdateScheduleUnits(ms, before, voidUnits); err == errScheduleChanged {
+		render.Render(w, r, ErrConflict(err))
+		return
+	} else if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &UnitPartsResponse{merged, NewMasterScheduleResponse(*ms, *g)})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	jdchaimailer "github.com/ede0m/jdchai/mailer"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxPhotoSize bounds condition report photo uploads
+const maxPhotoSize = 10 << 20
+
+// photoExts are the extensions photo keys are stored with, by the content type sniffed at upload
+var photoExts = map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/webp": ".webp"}
+
+// Stay is the usage log of a unit: who checked in and out and the condition the property was left in
+type Stay struct {
+	CheckIn  *StayEvent       `json:"checkIn,omitempty" bson:"checkIn,omitempty"`
+	CheckOut *StayEvent       `json:"checkOut,omitempty" bson:"checkOut,omitempty"`
+	Report   *ConditionReport `json:"report,omitempty" bson:"report,omitempty"`
+	Photos   []string         `json:"photos,omitempty" bson:"photos,omitempty"` // blob store keys, with the extension of their content type
+}
+
+// StayEvent records when and by whom a check-in or check-out was made
+type StayEvent struct {
+	At time.Time          `json:"at" bson:"at"`
+	By primitive.ObjectID `json:"by" bson:"by"`
+}
+
+// ConditionReport is the condition of the property at check-out
+type ConditionReport struct {
+	Issues bool   `json:"issues" bson:"issues"`
+	Notes  string `json:"notes" bson:"notes"`
+}
+
+// CheckOutRequest is a check-out with its condition report
+type CheckOutRequest struct {
+	ConditionReport
+}
+
+// StayResponse is the client response for a unit's stay
+type StayResponse struct {
+	UnitID string `json:"unitId"`
+	Stay   Stay   `json:"stay"`
+}
+
+// Bind binds the http req to checkOutRequest type as the render
+func (cor *CheckOutRequest) Bind(r *http.Request) error {
+	if cor.Issues && cor.Notes == "" {
+		return errors.New("reported issues must be described in notes")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (sr *StayResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// nextUnit returns the id of the unit following a unit in the schedule
+func (ms *MasterSchedule) nextUnit(id string) (string, bool) {
+	smu := ms.ScheduleUnitMap[id]
+	next, found := "", false
+	for nid, n := range ms.ScheduleUnitMap {
+		if !n.Start.After(smu.Start) {
+			continue
+		}
+		if !found || n.Start.Before(ms.ScheduleUnitMap[next].Start) {
+			next, found = nid, true
+		}
+	}
+	return next, found
+}
+
+/*stayUnit gets a unit of the request's schedule, checking the user stays in it or is admin of group.
+The owner stays in a unit, as do guests whose booking contact is their email. Stay routes only require
+observing the group so that guests who are observers can log their stay */
+func stayUnit(w http.ResponseWriter, r *http.Request) (*Group, *MasterSchedule, *User, string, bool) {
+	g, ms, u, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return nil, nil, nil, "", false
+	}
+	unitID := chi.URLParam(r, "unitID")
+	smu, ok := ms.ScheduleUnitMap[unitID]
+	if !ok {
+		render.Render(w, r, ErrNotFound(errors.New("unit not in schedule")))
+		return nil, nil, nil, "", false
+	}
+	if smu.Owner != u.Email && !smu.bookedBy(u.Email) && !g.Can(u.ID, ManageGroup) {
+		render.Render(w, r, ErrForbidden(errors.New("only the unit owner, its booked guests or a group admin can log a stay")))
+		return nil, nil, nil, "", false
+	}
+	return g, ms, u, unitID, true
+}
+
+// bookedBy reports whether a unit has a booking whose contact is email
+func (smu ScheduleMapUnit) bookedBy(email string) bool {
+	for _, b := range smu.Bookings {
+		if strings.EqualFold(strings.TrimSpace(b.Contact), email) {
+			return true
+		}
+	}
+	return false
+}
+
+func stayResponse(ms *MasterSchedule, unitID string) *StayResponse {
+	stay := Stay{}
+	if s := ms.ScheduleUnitMap[unitID].Stay; s != nil {
+		stay = *s
+	}
+	return &StayResponse{unitID, stay}
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetStay retrieves the usage log of a unit
+func GetStay(w http.ResponseWriter, r *http.Request) {
+	_, ms, _, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	unitID := chi.URLParam(r, "unitID")
+	if _, ok := ms.ScheduleUnitMap[unitID]; !ok {
+		render.Render(w, r, ErrNotFound(errors.New("unit not in schedule")))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, stayResponse(ms, unitID))
+}
+
+// CheckIn records the start of a stay in a unit
+func CheckIn(w http.ResponseWriter, r *http.Request) {
+	_, ms, u, unitID, ok := stayUnit(w, r)
+	if !ok {
+		return
+	}
+	smu := ms.ScheduleUnitMap[unitID]
+	if smu.Stay != nil && smu.Stay.CheckIn != nil {
+		render.Render(w, r, ErrConflict(errors.New("unit already checked in")))
+		return
+	}
+	smu.Stay = &Stay{CheckIn: &StayEvent{time.Now(), u.ID}}
+	ms.ScheduleUnitMap[unitID] = smu
+	// only the first of concurrent check-ins is recorded
+	filter := bson.M{"_id": ms.ID, "scheduleUnitMap." + unitID + ".stay.checkIn": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"scheduleUnitMap." + unitID + ".stay": smu.Stay}}
+	matched, err := mh.UpdateMasterScheduleIf(filter, update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrConflict(errors.New("unit already checked in")))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, stayResponse(ms, unitID))
+}
+
+/*CheckOut records the end of a stay in a unit with a condition report. The owner of the next unit
+is notified when issues are reported */
+func CheckOut(w http.ResponseWriter, r *http.Request) {
+	data := &CheckOutRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, ms, u, unitID, ok := stayUnit(w, r)
+	if !ok {
+		return
+	}
+	smu := ms.ScheduleUnitMap[unitID]
+	if smu.Stay == nil || smu.Stay.CheckIn == nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("unit must be checked in before checking out")))
+		return
+	}
+	if smu.Stay.CheckOut != nil {
+		render.Render(w, r, ErrConflict(errors.New("unit already checked out")))
+		return
+	}
+	smu.Stay.CheckOut = &StayEvent{time.Now(), u.ID}
+	smu.Stay.Report = &data.ConditionReport
+	ms.ScheduleUnitMap[unitID] = smu
+	filter := bson.M{"_id": ms.ID, "scheduleUnitMap." + unitID + ".stay.checkOut": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{
+		"scheduleUnitMap." + unitID + ".stay.checkOut": smu.Stay.CheckOut,
+		"scheduleUnitMap." + unitID + ".stay.report":   smu.Stay.Report,
+	}}
+	matched, err := mh.UpdateMasterScheduleIf(filter, update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !matched {
+		render.Render(w, r, ErrConflict(errors.New("unit already checked out")))
+		return
+	}
+	if next, found := ms.nextUnit(unitID); found && data.Issues {
+		if n := ms.ScheduleUnitMap[next]; n.Owner != "" && notifies(n.Owner, StayReportNotification) {
+			go jdchaimailer.SendConditionReport(g.Name, n.Owner, n.Start.Format(exportDateLayout), data.Notes)
+		}
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, stayResponse(ms, unitID))
+}
+
+// UploadStayPhoto adds a jpeg, png or webp photo (multipart field photo) to a unit's stay
+func UploadStayPhoto(w http.ResponseWriter, r *http.Request) {
+	_, ms, _, unitID, ok := stayUnit(w, r)
+	if !ok {
+		return
+	}
+	smu := ms.ScheduleUnitMap[unitID]
+	if smu.Stay == nil || smu.Stay.CheckIn == nil {
+		render.Render(w, r, ErrInvalidRequest(errors.New("unit must be checked in before adding photos")))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoSize)
+	f, _, err := r.FormFile("photo")
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	defer f.Close()
+	// the type is sniffed from the content, never taken from the client
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	ext, ok := photoExts[http.DetectContentType(head[:n])]
+	if !ok {
+		render.Render(w, r, ErrInvalidRequest(errors.New("photo must be a jpeg, png or webp image")))
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	key := "stay/" + ms.ID.Hex() + "/" + unitID + "/" + primitive.NewObjectID().Hex() + ext
+	if err = blobStore.Put(key, f); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	smu.Stay.Photos = append(smu.Stay.Photos, key)
+	ms.ScheduleUnitMap[unitID] = smu
+	update := bson.M{"$push": bson.M{"scheduleUnitMap." + unitID + ".stay.photos": key}}
+	if err = mh.UpdateMasterSchedule(bson.M{"_id": ms.ID}, update); err != nil {
+		blobStore.Delete(key)
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, stayResponse(ms, unitID))
+}
+
+// GetStayPhoto serves a photo of a unit's stay (?key=photo key)
+func GetStayPhoto(w http.ResponseWriter, r *http.Request) {
+	_, ms, _, errResp := unitRequestSchedule(r)
+	if errResp != nil {
+		render.Render(w, r, errResp)
+		return
+	}
+	unitID, key := chi.URLParam(r, "unitID"), r.URL.Query().Get("key")
+	stay := ms.ScheduleUnitMap[unitID].Stay
+	if stay == nil || indexOf(key, stay.Photos) < 0 {
+		render.Render(w, r, ErrNotFound(errors.New("photo not in stay")))
+		return
+	}
+	photo, err := blobStore.Get(key)
+	if err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	defer photo.Close()
+	// serve the type sniffed at upload, and do not let the browser sniff another
+	contentType := "application/octet-stream"
+	for t, ext := range photoExts {
+		if filepath.Ext(key) == ext {
+			contentType = t
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "inline")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, photo)
+}
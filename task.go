@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	jdchaimailer "github.com/ede0m/jdchai/mailer"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Task assignments
+const (
+	UnitAssignment   = "unit"   // the owner of a given unit
+	RotateAssignment = "rotate" // the owner of the unit the task is due in, so it rotates with the schedule
+)
+
+// taskReminderLead is how long before a task is due its assignee is reminded
+const taskReminderLead = 48 * time.Hour
+
+// Task is a maintenance job on the group's property
+type Task struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	GroupID     primitive.ObjectID `json:"groupId" bson:"groupId"`
+	Title       string             `json:"title" bson:"title"`
+	Notes       string             `json:"notes" bson:"notes"`
+	Due         time.Time          `json:"due" bson:"due"`
+	Assignment  string             `json:"assignment" bson:"assignment"`
+	UnitID      string             `json:"unitId" bson:"unitId,omitempty"` // for unit assignments
+	ResourceID  primitive.ObjectID `json:"resourceId" bson:"resourceId,omitempty"`
+	Completed   bool               `json:"completed" bson:"completed"`
+	CompletedBy primitive.ObjectID `json:"completedBy" bson:"completedBy,omitempty"`
+	CompletedAt time.Time          `json:"completedAt" bson:"completedAt,omitempty"`
+	RemindedAt  time.Time          `json:"remindedAt" bson:"remindedAt,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// TaskRequest is a request by a group admin to add a task
+type TaskRequest struct {
+	Title      string    `json:"title"`
+	Notes      string    `json:"notes"`
+	Due        time.Time `json:"due"`
+	Assignment string    `json:"assignment"`
+	UnitID     string    `json:"unitId"`
+	ResourceID string    `json:"resourceId"`
+}
+
+// TaskResponse is a task with the owner it is currently assigned to
+type TaskResponse struct {
+	Task
+	Assignee string `json:"assignee"`
+}
+
+// TasksResponse lists a group's tasks by season, keyed by the year the season opens
+type TasksResponse struct {
+	Seasons map[int][]TaskResponse `json:"seasons"`
+}
+
+// Bind binds the http req to taskRequest type as the render
+func (tr *TaskRequest) Bind(r *http.Request) error {
+	if tr.Title == "" {
+		return errors.New("task must have a title")
+	}
+	if tr.Due.IsZero() {
+		return errors.New("task must have a due date")
+	}
+	if tr.Assignment == "" {
+		tr.Assignment = RotateAssignment
+	}
+	if tr.Assignment != UnitAssignment && tr.Assignment != RotateAssignment {
+		return errors.New("task assignment should be unit or rotate")
+	}
+	if tr.Assignment == UnitAssignment && tr.UnitID == "" {
+		return errors.New("unit assigned tasks must have a unit")
+	}
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (tr *TaskResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (tr *TasksResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// dueDay is the day a task is due at the property, comparable with unit and season dates
+func (t Task) dueDay(loc *time.Location) time.Time {
+	due := t.Due.In(loc)
+	return time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// assignee is the owner a task is assigned to in a master schedule of a group in loc
+func (t Task) assignee(ms *MasterSchedule, loc *time.Location) string {
+	if ms == nil {
+		return ""
+	}
+	if t.Assignment == UnitAssignment {
+		return ms.ScheduleUnitMap[t.UnitID].Owner
+	}
+	// the unit the task is due in, or the first one after
+	day := t.dueDay(loc)
+	owner, start := "", time.Time{}
+	for _, smu := range ms.ScheduleUnitMap {
+		if smu.EndDate().Before(day) {
+			continue
+		}
+		if owner == "" || smu.Start.Before(start) {
+			owner, start = smu.Owner, smu.Start
+		}
+	}
+	return owner
+}
+
+/*seasonOf is the year the schedule season a day falls in opens, or the next season's when the day
+falls between seasons. Days after the last season, or without a schedule, fall in their own year */
+func (ms *MasterSchedule) seasonOf(day time.Time) int {
+	if ms != nil {
+		for _, s := range ms.Schedule.Seasons {
+			if !day.After(s.CloseWeek.AddDate(0, 0, unitDays)) {
+				return s.OpenWeek.Year()
+			}
+		}
+	}
+	return day.Year()
+}
+
+// taskSchedules loads the current master schedule of each resource a group's tasks are for
+func taskSchedules(groupID primitive.ObjectID, tasks []*Task) map[primitive.ObjectID]*MasterSchedule {
+	schs := make(map[primitive.ObjectID]*MasterSchedule)
+	for _, t := range tasks {
+		if _, ok := schs[t.ResourceID]; ok {
+			continue
+		}
+		ms := &MasterSchedule{}
+		if err := mh.GetMasterSchedule(ms, masterScheduleFilter(groupID, t.ResourceID)); err != nil {
+			ms = nil
+		}
+		schs[t.ResourceID] = ms
+	}
+	return schs
+}
+
+// NewTasksResponse lists tasks by the schedule season they are due in, earliest first
+func NewTasksResponse(g Group, tasks []*Task, openOnly bool) *TasksResponse {
+	schs := taskSchedules(g.ID, tasks)
+	loc := g.Location()
+	resp := &TasksResponse{make(map[int][]TaskResponse)}
+	for _, t := range tasks {
+		if openOnly && t.Completed {
+			continue
+		}
+		ms := schs[t.ResourceID]
+		season := ms.seasonOf(t.dueDay(loc))
+		resp.Seasons[season] = append(resp.Seasons[season], TaskResponse{*t, t.assignee(ms, loc)})
+	}
+	for _, season := range resp.Seasons {
+		sort.Slice(season, func(i, j int) bool { return season[i].Due.Before(season[j].Due) })
+	}
+	return resp
+}
+
+/*RunTaskReminders emails the assignee of every open task coming due, or the group admins when the task
+has no assignee, once per task. It checks every interval until the process exits */
+func RunTaskReminders(interval time.Duration) {
+	for {
+		remindTasks(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func remindTasks(now time.Time) {
+	tasks, err := mh.GetTasks(bson.M{
+		"completed":  false,
+		"remindedAt": bson.M{"$exists": false},
+		"due":        bson.M{"$lte": now.Add(taskReminderLead)},
+	})
+	if err != nil {
+		log.Println("task reminders: " + err.Error())
+		return
+	}
+	byGroup := make(map[primitive.ObjectID][]*Task)
+	var groupIDs []primitive.ObjectID
+	for _, t := range tasks {
+		if _, ok := byGroup[t.GroupID]; !ok {
+			groupIDs = append(groupIDs, t.GroupID)
+		}
+		byGroup[t.GroupID] = append(byGroup[t.GroupID], t)
+	}
+	if len(groupIDs) == 0 {
+		return
+	}
+	groups, err := mh.GetGroups(bson.M{"_id": bson.M{"$in": groupIDs}, "deletedAt": bson.M{"$exists": false}})
+	if err != nil {
+		log.Println("task reminders: " + err.Error())
+		return
+	}
+	for _, g := range groups {
+		loc := g.Location()
+		schs := taskSchedules(g.ID, byGroup[g.ID])
+		var admins []string
+		for _, t := range byGroup[g.ID] {
+			// tasks without an owner to remind are reported to the group admins instead
+			send, to := jdchaimailer.SendTaskReminder, []string{}
+			if email := t.assignee(schs[t.ResourceID], loc); email == "" {
+				if admins == nil {
+					admins = taskAdmins(g)
+				}
+				send, to = jdchaimailer.SendUnassignedTask, admins
+			} else if notifies(email, TaskReminderNotification) {
+				to = append(to, email)
+			}
+			if len(to) == 0 {
+				continue
+			}
+			// claim the reminder so it is sent once
+			filter := bson.M{"_id": t.ID, "remindedAt": bson.M{"$exists": false}}
+			claimed, err := mh.UpdateTask(filter, bson.M{"$set": bson.M{"remindedAt": now}})
+			if err != nil {
+				log.Println("task reminders: " + err.Error())
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			for _, email := range to {
+				go send(g.Name, email, t.Title, t.Due.In(loc).Format(exportDateLayout))
+			}
+		}
+	}
+}
+
+// taskAdmins are the emails of a group's admins who get task notifications
+func taskAdmins(g *Group) []string {
+	emails := make([]string, 0, len(g.Admins))
+	if len(g.Admins) == 0 {
+		return emails
+	}
+	admins, err := mh.GetUsers(bson.M{"_id": bson.M{"$in": g.Admins}})
+	if err != nil {
+		log.Println("task reminders: " + err.Error())
+		return emails
+	}
+	for _, u := range admins {
+		if u.Notifies(TaskReminderNotification) {
+			emails = append(emails, u.Email)
+		}
+	}
+	return emails
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetTasks lists a group's tasks by season (?season=year the season opens&open=true)
+func GetTasks(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	q := r.URL.Query()
+	tasks, err := mh.GetTasks(bson.M{"groupId": g.ID})
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	resp := NewTasksResponse(*g, tasks, q.Get("open") == "true")
+	if q.Get("season") != "" {
+		season, err := strconv.Atoi(q.Get("season"))
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		resp.Seasons = map[int][]TaskResponse{season: resp.Seasons[season]}
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, resp)
+}
+
+// CreateTask adds a task to a group
+func CreateTask(w http.ResponseWriter, r *http.Request) {
+	data := &TaskRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	g, _ := requestGroup(r)
+	resourceID, err := g.resourceID(data.ResourceID)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	t := &Task{ID: primitive.NewObjectID(), GroupID: g.ID, Title: data.Title, Notes: data.Notes, Due: data.Due, Assignment: data.Assignment,
+		ResourceID: resourceID, CreatedAt: time.Now()}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, masterScheduleFilter(g.ID, resourceID)); err != nil {
+		ms = nil
+	}
+	if data.Assignment == UnitAssignment {
+		if ms == nil {
+			render.Render(w, r, ErrInvalidRequest(errors.New("resource has no schedule to assign units from")))
+			return
+		}
+		if _, ok := ms.ScheduleUnitMap[data.UnitID]; !ok {
+			render.Render(w, r, ErrInvalidRequest(errors.New("unit not in schedule")))
+			return
+		}
+		t.UnitID = data.UnitID
+	}
+	if _, err = mh.InsertTask(t); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &TaskResponse{*t, t.assignee(ms, g.Location())})
+}
+
+// CompleteTask marks a task done. Only its assignee or a group admin can complete it
+func CompleteTask(w http.ResponseWriter, r *http.Request) {
+	g, uid := requestGroup(r)
+	taskID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "taskID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	t := &Task{}
+	if err = mh.GetTask(t, bson.M{"_id": taskID, "groupId": g.ID}); err != nil {
+		render.Render(w, r, ErrNotFound(errors.New("task not in group")))
+		return
+	}
+	if t.Completed {
+		render.Render(w, r, ErrConflict(errors.New("task already completed")))
+		return
+	}
+	ms := taskSchedules(g.ID, []*Task{t})[t.ResourceID]
+	assignee := t.assignee(ms, g.Location())
+	if !g.Can(uid, ManageGroup) {
+		u := &User{}
+		if err = mh.GetUser(u, bson.M{"_id": uid}); err != nil || u.Email != assignee {
+			render.Render(w, r, ErrForbidden(errors.New("only the assignee or a group admin can complete a task")))
+			return
+		}
+	}
+	t.Completed, t.CompletedBy, t.CompletedAt = true, uid, time.Now()
+	update := bson.M{"$set": bson.M{"completed": true, "completedBy": uid, "completedAt": t.CompletedAt}}
+	completed, err := mh.UpdateTask(bson.M{"_id": taskID, "completed": false}, update)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if !completed {
+		render.Render(w, r, ErrConflict(errors.New("task already completed")))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, &TaskResponse{*t, assignee})
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth"
 	"github.com/go-chi/render"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
@@ -37,17 +36,19 @@ type Trade struct {
 
 // TradeUnit wraps a trade id and its specs
 type TradeUnit struct {
-	ID        uuid.UUID `json:"id" bson:"_id"`
-	UnitStart time.Time `json:"unitStart" bson:"unitStart"`
+	ID         uuid.UUID          `json:"id" bson:"_id"`
+	UnitStart  time.Time          `json:"unitStart" bson:"unitStart"`
+	ScheduleID primitive.ObjectID `json:"scheduleId" bson:"scheduleId,omitempty"` // set when the unit is in another resource's schedule
 }
 
 // TradeRequest for creating a new trade
 type TradeRequest struct {
-	ScheduleID      string   `json:"scheduleId"`
-	InitiatorEmail  string   `json:"initiatorEmail"`
-	ExecutorEmail   string   `json:"executorEmail"`
-	InitiatorTrades []string `json:"initiatorTrades"`
-	ExecutorTrades  []string `json:"executorTrades"`
+	ScheduleID         string   `json:"scheduleId"`
+	ExecutorScheduleID string   `json:"executorScheduleId"` // schedule of the executor trades when it is another resource of the group
+	InitiatorEmail     string   `json:"initiatorEmail"`
+	ExecutorEmail      string   `json:"executorEmail"`
+	InitiatorTrades    []string `json:"initiatorTrades"`
+	ExecutorTrades     []string `json:"executorTrades"`
 }
 
 // FinalizeTradeRequest for accepting an existing trade
@@ -71,6 +72,7 @@ type UserTradesResponse struct {
 type GroupTrades struct {
 	ScheduleID primitive.ObjectID `json:"scheduleId" bson:"_id"`
 	GroupID    primitive.ObjectID `json:"groupId" bson:"groupId"`
+	ResourceID primitive.ObjectID `json:"resourceId" bson:"resourceId,omitempty"`
 	Trades     []Trade            `json:"trades" bson:"trades"`
 }
 
@@ -123,9 +125,12 @@ func NewTradeResponse(t Trade) *TradeResponse {
 func NewUserTradesResponse(gt []GroupTrades) *UserTradesResponse {
 
 	userGroupTrades := make(map[string]GroupTrades)
-	// TODO: parse to map
+	// keyed by group, or group/resource for a group's added resources
 	for _, g := range gt {
 		gid := g.GroupID.Hex()
+		if !g.ResourceID.IsZero() {
+			gid += "/" + g.ResourceID.Hex()
+		}
 		if _, found := userGroupTrades[gid]; !found {
 			userGroupTrades[gid] = g
 		}
@@ -151,6 +156,21 @@ func NewTrade(tr *TradeRequest, reqUserID string) (*Trade, error) {
 	if err != nil {
 		return nil, err
 	}
+	// executor trades may come from another resource's schedule in the same group
+	execSch := sch
+	if tr.ExecutorScheduleID != "" && tr.ExecutorScheduleID != tr.ScheduleID {
+		execSchID, err := primitive.ObjectIDFromHex(tr.ExecutorScheduleID)
+		if err != nil {
+			return nil, err
+		}
+		execSch = &MasterSchedule{}
+		if err = mh.GetMasterSchedule(execSch, bson.M{"_id": execSchID}); err != nil {
+			return nil, err
+		}
+		if execSch.GroupID != sch.GroupID {
+			return nil, errors.New("trades across schedules must be within one group")
+		}
+	}
 	// check users exist
 	initUser, execUser := &User{}, &User{}
 	err = mh.GetUser(initUser, bson.M{"email": tr.InitiatorEmail})
@@ -176,12 +196,15 @@ func NewTrade(tr *TradeRequest, reqUserID string) (*Trade, error) {
 	if !g.HasUser(initUser.ID) || !g.HasUser(execUser.ID) {
 		return nil, errors.New("one trade member does not belong to group")
 	}
+	if !g.Can(initUser.ID, UseGroup) || !g.Can(execUser.ID, UseGroup) {
+		return nil, errors.New("group viewers cannot trade")
+	}
 
 	// check that initiator trades belong to initiator
 	initTrades, execTrades := []TradeUnit{}, []TradeUnit{}
 	for _, guid := range tr.InitiatorTrades {
 		if v, ok := sch.ScheduleUnitMap[guid]; ok {
-			initTrades = append(initTrades, TradeUnit{uuid.MustParse(guid), v.Start})
+			initTrades = append(initTrades, TradeUnit{uuid.MustParse(guid), v.Start, primitive.NilObjectID})
 			if v.Owner != tr.InitiatorEmail {
 				return nil, errors.New(guid + " not owned by " + tr.InitiatorEmail)
 			}
@@ -190,9 +213,13 @@ func NewTrade(tr *TradeRequest, reqUserID string) (*Trade, error) {
 		}
 	}
 	// check that executor trades belong to executor
+	var execTradeSchID primitive.ObjectID
+	if execSch != sch {
+		execTradeSchID = execSch.ID
+	}
 	for _, guid := range tr.ExecutorTrades {
-		if v, ok := sch.ScheduleUnitMap[guid]; ok {
-			execTrades = append(execTrades, TradeUnit{uuid.MustParse(guid), v.Start})
+		if v, ok := execSch.ScheduleUnitMap[guid]; ok {
+			execTrades = append(execTrades, TradeUnit{uuid.MustParse(guid), v.Start, execTradeSchID})
 			if v.Owner != tr.ExecutorEmail {
 				return nil, errors.New(guid + " not owned by " + tr.ExecutorEmail)
 			}
@@ -204,6 +231,16 @@ func NewTrade(tr *TradeRequest, reqUserID string) (*Trade, error) {
 	return &Trade{primitive.NewObjectID(), time.Now(), tr.InitiatorEmail, tr.ExecutorEmail, initTrades, execTrades, Open}, nil
 }
 
+// executorScheduleID is the schedule of the executor trades when it differs from the trade's schedule
+func (t Trade) executorScheduleID() primitive.ObjectID {
+	for _, tu := range t.ExecutorTrades {
+		if !tu.ScheduleID.IsZero() {
+			return tu.ScheduleID
+		}
+	}
+	return primitive.NilObjectID
+}
+
 // CreateTrade creates a new trade
 func CreateTrade(w http.ResponseWriter, r *http.Request) {
 	data := &TradeRequest{}
@@ -213,8 +250,12 @@ func CreateTrade(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// request auth is from initiator
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	trade, err := NewTrade(data, claims["userID"].(string))
+	uid := requestUserID(r)
+	if uid.IsZero() {
+		render.Render(w, r, ErrAuth(errors.New("token does not name a user")))
+		return
+	}
+	trade, err := NewTrade(data, uid.Hex())
 	if err != nil {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
@@ -241,8 +282,11 @@ func FinalizeTrade(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	uid, _ := primitive.ObjectIDFromHex(claims["userID"].(string))
+	uid := requestUserID(r)
+	if uid.IsZero() {
+		render.Render(w, r, ErrAuth(errors.New("token does not name a user")))
+		return
+	}
 	tid, _ := primitive.ObjectIDFromHex(data.TradeID)
 	schid, _ := primitive.ObjectIDFromHex(data.ScheduleID)
 	u := &User{}
@@ -255,8 +299,8 @@ func FinalizeTrade(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrNotFound(err))
 		return
 	}
-	if t.Status == 2 || t.Status == 3 {
-		render.Render(w, r, ErrInvalidRequest(errors.New("trade is void or cancelled")))
+	if t.Status != Open {
+		render.Render(w, r, ErrInvalidRequest(errors.New("trade is executed, void or cancelled")))
 		return
 	}
 
@@ -265,15 +309,19 @@ func FinalizeTrade(w http.ResponseWriter, r *http.Request) {
 	if t.ExecutorEmail == u.Email {
 		// Executor
 		if data.Action == 1 {
-			// Accepted:
-			// TODO: incorperate lock??
-			sch := &MasterSchedule{}
-			if err := mh.GetMasterSchedule(sch, bson.M{"_id": schid}); err != nil {
-				render.Render(w, r, ErrNotFound(err))
+			// Accepted: ExecuteTrade checks the traders still own their units
+			execSchID := t.executorScheduleID()
+			if execSchID.IsZero() {
+				execSchID = schid
+			}
+			err := mh.ExecuteTrade(t, schid, execSchID)
+			if err == errStaleTrade {
+				// the units changed hands since the trade was made, it can never execute
+				mh.UpdateTrade(bson.M{"_id": schid, "tradeLedger": bson.M{"$elemMatch": bson.M{"_id": tid, "status": Open}}}, bson.M{"$set": bson.M{"tradeLedger.$.status": Void}})
+				render.Render(w, r, ErrConflict(err))
 				return
 			}
-			sch.Schedule, sch.ScheduleUnitMap = sch.tradeScheduleUnits(*t)
-			if err := mh.ExecuteTrade(t, sch); err != nil {
+			if err != nil {
 				render.Render(w, r, ErrServer(err))
 				return
 			}
@@ -300,11 +348,16 @@ func FinalizeTrade(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetUserTrades gets all trades belonging to a user's current groups
+// GetUserTrades gets all trades belonging to a user's current groups. Users can only get their own trades
 func GetUserTrades(w http.ResponseWriter, r *http.Request) {
 	uid := chi.URLParam(r, "userID")
 	uID, err := primitive.ObjectIDFromHex(uid)
 	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if uID != requestUserID(r) {
+		render.Render(w, r, ErrForbidden(errors.New("not authorized for this user's trades")))
 		return
 	}
 	// TODO: remove this query and combine with $lookup in GetActiveScheduleUserTrades
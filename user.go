@@ -18,14 +18,19 @@ var nilTime time.Time
 
 // User for login/register
 type User struct {
-	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	Email       string               `json:"email" bson:"email"`
-	Password    string               `json:"password" bson:"password"`
-	FirstName   string               `json:"firstName" bson:"firstName"`
-	LastName    string               `json:"lastName" bson:"lastName"`
-	CreatedAt   time.Time            `json:"createdAt" bson:"createdAt"`
-	ActivatedAt time.Time            `json:"activatedAt" bson:"activatedAt"`
-	Groups      []primitive.ObjectID `json:"groups" bson:"groups"`
+	ID                 primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Email              string               `json:"email" bson:"email"`
+	Password           string               `json:"password" bson:"password"`
+	FirstName          string               `json:"firstName" bson:"firstName"`
+	LastName           string               `json:"lastName" bson:"lastName"`
+	CreatedAt          time.Time            `json:"createdAt" bson:"createdAt"`
+	ActivatedAt        time.Time            `json:"activatedAt" bson:"activatedAt"`
+	Groups             []primitive.ObjectID `json:"groups" bson:"groups"`
+	Phone              string               `json:"phone" bson:"phone,omitempty"`
+	Locale             string               `json:"locale" bson:"locale,omitempty"`
+	TimeZone           string               `json:"timeZone" bson:"timeZone,omitempty"`
+	MutedNotifications []string             `json:"mutedNotifications" bson:"mutedNotifications,omitempty"`
+	PendingEmail       string               `json:"pendingEmail" bson:"pendingEmail,omitempty"` // new email awaiting verification
 }
 
 // RegisterRequest request
@@ -53,6 +58,50 @@ type UserResponse struct {
 	Token     string             `json:"token"`
 }
 
+// UserGroupSummary is one of a user's groups with the user's place in it
+type UserGroupSummary struct {
+	ID            primitive.ObjectID `json:"id"`
+	Name          string             `json:"name"`
+	Role          Role               `json:"role"`
+	NMembers      int                `json:"nMembers"`
+	NextUnit      *UpcomingUnit      `json:"nextUnit"` // nil when the user has no units to come
+	PendingTrades int                `json:"pendingTrades"`
+}
+
+// UpcomingUnit is the next unit a user owns in a group, with its check-in and check-out instants
+type UpcomingUnit struct {
+	ScheduleID primitive.ObjectID `json:"scheduleId"`
+	ResourceID primitive.ObjectID `json:"resourceId"`
+	UnitID     string             `json:"unitId"`
+	Start      time.Time          `json:"start"`
+	End        time.Time          `json:"end"`
+}
+
+// UserGroupsResponse lists the caller's groups by name
+type UserGroupsResponse struct {
+	Groups []UserGroupSummary `json:"groups"`
+}
+
+// UserGroupDoc is a group of a user with the user's share of its current schedules, as aggregated by GetUserGroups
+type UserGroupDoc struct {
+	Group     Group                 `bson:"group"`
+	Schedules []UserScheduleSummary `bson:"schedules"`
+}
+
+// UserScheduleSummary is a user's next unit and open trade count in one schedule
+type UserScheduleSummary struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	ResourceID    primitive.ObjectID `bson:"resourceId"`
+	Next          *UnitEntry         `bson:"next"`
+	PendingTrades int                `bson:"pendingTrades"`
+}
+
+// UnitEntry is an entry of a schedule unit map
+type UnitEntry struct {
+	UnitID string          `bson:"k"`
+	Unit   ScheduleMapUnit `bson:"v"`
+}
+
 // GroupUserResponse is a group's representation of a user
 type GroupUserResponse struct {
 	FirstName string `json:"firstName"`
@@ -76,7 +125,8 @@ func NewUser(rr RegisterRequest) (*User, error) {
 	// first group
 	groups := make([]primitive.ObjectID, 1)
 	groups[0] = rr.GroupID
-	return &User{primitive.NilObjectID, rr.Email, string(hashedPassword), rr.FirstName, rr.LastName, createdAt, nilTime, groups}, nil
+	return &User{Email: rr.Email, Password: string(hashedPassword), FirstName: rr.FirstName, LastName: rr.LastName,
+		CreatedAt: createdAt, ActivatedAt: nilTime, Groups: groups}, nil
 }
 
 // NewUserResponse constructor for UserResponse
@@ -98,6 +148,31 @@ func NewGroupUserResponse(u User) *GroupUserResponse {
 	return &GroupUserResponse{u.FirstName, u.LastName, u.Email}
 }
 
+// NewUserGroupsResponse summarizes a user's aggregated groups
+func NewUserGroupsResponse(uid primitive.ObjectID, docs []UserGroupDoc) *UserGroupsResponse {
+	groups := make([]UserGroupSummary, 0, len(docs))
+	for _, doc := range docs {
+		g := doc.Group
+		role, _ := g.Role(uid)
+		summary := UserGroupSummary{ID: g.ID, Name: g.Name, Role: role, NMembers: len(g.Members)}
+		for _, sch := range doc.Schedules {
+			summary.PendingTrades += sch.PendingTrades
+			if sch.Next == nil || (summary.NextUnit != nil && !sch.Next.Unit.Start.Before(summary.NextUnit.Start)) {
+				continue
+			}
+			start, end := g.UnitBounds(sch.Next.Unit.Start, sch.Next.Unit.EndDate())
+			summary.NextUnit = &UpcomingUnit{sch.ID, sch.ResourceID, sch.Next.UnitID, start, end}
+		}
+		groups = append(groups, summary)
+	}
+	return &UserGroupsResponse{groups}
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (ugr *UserGroupsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 // Render is called in top-down order, like a http handler middleware chain.
 func (ur *UserResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
@@ -170,6 +245,18 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, NewUserResponse(*user))
 }
 
+// GetUserGroups lists the caller's groups, optionally searched by name (?q=), with their next unit and pending trades
+func GetUserGroups(w http.ResponseWriter, r *http.Request) {
+	uid := requestUserID(r)
+	docs, err := mh.GetUserGroups(uid, r.URL.Query().Get("q"), time.Now())
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewUserGroupsResponse(uid, docs))
+}
+
 func (u User) inGroup(gid primitive.ObjectID) bool {
 	for _, gID := range u.Groups {
 		if gID == gid {
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MasterScheduleVersion summarizes one stored master schedule of a group
+type MasterScheduleVersion struct {
+	ID        primitive.ObjectID `json:"id"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Seasons   int                `json:"seasons"`
+	Units     int                `json:"units"`
+	Trades    int                `json:"trades"`
+}
+
+// MasterScheduleVersionsResponse lists every master schedule version of a group, newest first
+type MasterScheduleVersionsResponse struct {
+	GroupID  primitive.ObjectID      `json:"groupId"`
+	Versions []MasterScheduleVersion `json:"versions"`
+}
+
+// UnitDiff is a unit that only exists in one of two compared schedules
+type UnitDiff struct {
+	UnitID string    `json:"unitId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Owner  string    `json:"owner"`
+}
+
+// UnitOwnerChange is a unit that exists in both compared schedules under a different owner
+type UnitOwnerChange struct {
+	FromUnitID string    `json:"fromUnitId"`
+	ToUnitID   string    `json:"toUnitId"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	FromOwner  string    `json:"fromOwner"`
+	ToOwner    string    `json:"toOwner"`
+}
+
+// OwnerDiff counts the units an owner gained and lost between two schedules
+type OwnerDiff struct {
+	Gained int `json:"gained"`
+	Lost   int `json:"lost"`
+}
+
+// ScheduleDiffResponse is a unit level diff between two master schedule versions
+type ScheduleDiffResponse struct {
+	From         primitive.ObjectID   `json:"from"`
+	To           primitive.ObjectID   `json:"to"`
+	OwnerChanges []UnitOwnerChange    `json:"ownerChanges"`
+	Added        []UnitDiff           `json:"added"`
+	Removed      []UnitDiff           `json:"removed"`
+	Owners       map[string]OwnerDiff `json:"owners"`
+}
+
+// NewMasterScheduleVersionsResponse summarizes schedule versions
+func NewMasterScheduleVersionsResponse(groupID primitive.ObjectID, schs []*MasterSchedule) *MasterScheduleVersionsResponse {
+	versions := make([]MasterScheduleVersion, 0)
+	for _, ms := range schs {
+		versions = append(versions, MasterScheduleVersion{ms.ID, ms.CreatedAt, len(ms.Schedule.Seasons), len(ms.ScheduleUnitMap), len(ms.TradeLedger)})
+	}
+	return &MasterScheduleVersionsResponse{groupID, versions}
+}
+
+/*NewScheduleDiff compares two master schedules unit by unit. Regenerated schedules get new unit ids,
+so units are matched on their start and end dates, as regeneration matches them */
+func NewScheduleDiff(from, to MasterSchedule) *ScheduleDiffResponse {
+
+	fromUnits := make(map[unitSpan]UnitDiff)
+	for id, smu := range from.ScheduleUnitMap {
+		fromUnits[spanOf(smu.Start, smu.EndDate())] = UnitDiff{id, smu.Start, smu.EndDate(), smu.Owner}
+	}
+	toUnits := make(map[unitSpan]UnitDiff)
+	for id, smu := range to.ScheduleUnitMap {
+		toUnits[spanOf(smu.Start, smu.EndDate())] = UnitDiff{id, smu.Start, smu.EndDate(), smu.Owner}
+	}
+
+	diff := &ScheduleDiffResponse{
+		From:         from.ID,
+		To:           to.ID,
+		OwnerChanges: make([]UnitOwnerChange, 0),
+		Added:        make([]UnitDiff, 0),
+		Removed:      make([]UnitDiff, 0),
+		Owners:       make(map[string]OwnerDiff),
+	}
+	gain := func(owner string) {
+		od := diff.Owners[owner]
+		od.Gained++
+		diff.Owners[owner] = od
+	}
+	lose := func(owner string) {
+		od := diff.Owners[owner]
+		od.Lost++
+		diff.Owners[owner] = od
+	}
+
+	for span, fu := range fromUnits {
+		tu, ok := toUnits[span]
+		if !ok {
+			diff.Removed = append(diff.Removed, fu)
+			lose(fu.Owner)
+			continue
+		}
+		if fu.Owner != tu.Owner {
+			diff.OwnerChanges = append(diff.OwnerChanges, UnitOwnerChange{fu.UnitID, tu.UnitID, fu.Start, fu.End, fu.Owner, tu.Owner})
+			lose(fu.Owner)
+			gain(tu.Owner)
+		}
+	}
+	for span, tu := range toUnits {
+		if _, ok := fromUnits[span]; !ok {
+			diff.Added = append(diff.Added, tu)
+			gain(tu.Owner)
+		}
+	}
+
+	sort.Slice(diff.OwnerChanges, func(i, j int) bool { return diff.OwnerChanges[i].Start.Before(diff.OwnerChanges[j].Start) })
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Start.Before(diff.Added[j].Start) })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Start.Before(diff.Removed[j].Start) })
+	return diff
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (vr *MasterScheduleVersionsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Render is called in top-down order, like a http handler middleware chain.
+func (dr *ScheduleDiffResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+////////////  CONTROLLERS //////////////////
+
+// GetMasterScheduleVersions lists every master schedule a group resource has had (?resource=id)
+func GetMasterScheduleVersions(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	filter, err := groupScheduleFilter(r, *g)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	schs, err := mh.GetMasterSchedules(filter)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewMasterScheduleVersionsResponse(g.ID, schs))
+}
+
+// GetMasterScheduleVersion retrieves one master schedule version of a group
+func GetMasterScheduleVersion(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	schID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "scheduleID"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ms := &MasterSchedule{}
+	if err = mh.GetMasterSchedule(ms, bson.M{"_id": schID, "groupId": g.ID}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewMasterScheduleResponse(*ms, *g))
+}
+
+// DiffMasterSchedules compares two master schedule versions of a group (?from=id&to=id)
+func DiffMasterSchedules(w http.ResponseWriter, r *http.Request) {
+	g, _ := requestGroup(r)
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		render.Render(w, r, ErrInvalidRequest(errors.New("must specify from and to schedule ids")))
+		return
+	}
+	fromID, err := primitive.ObjectIDFromHex(q.Get("from"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	toID, err := primitive.ObjectIDFromHex(q.Get("to"))
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	from, to := &MasterSchedule{}, &MasterSchedule{}
+	if err = mh.GetMasterSchedule(from, bson.M{"_id": fromID, "groupId": g.ID}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	if err = mh.GetMasterSchedule(to, bson.M{"_id": toID, "groupId": g.ID}); err != nil {
+		render.Render(w, r, ErrNotFound(err))
+		return
+	}
+	render.Status(r, http.StatusOK)
+	render.Render(w, r, NewScheduleDiff(*from, *to))
+}